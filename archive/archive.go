@@ -2,7 +2,10 @@ package archive
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/fs"
+	"path/filepath"
 )
 
 // An Archive represents a collection of files stored in a single file.
@@ -14,13 +17,7 @@ type Archive struct {
 // TotalSize returns the total size of the archive in bytes.
 // It includes the header and all the files' compressed data.
 func (a *Archive) TotalSize() uint64 {
-	var total uint64 = uint64(a.Header.HeaderLength)
-
-	for _, file := range a.Files {
-		total += uint64(file.CompressedSize())
-	}
-
-	return total
+	return a.Header.TotalSize()
 }
 
 // GetBytes returns the archive as a byte slice.
@@ -50,13 +47,18 @@ func (a *Archive) Write(w io.Writer) error {
 
 // ReadArchive reads an archive from the provided reader.
 // It reads all the files and the header, and returns an Archive struct.
-// It doesn't close the reader.
+// It doesn't close the reader. Archives with encrypted entries return an ErrEncrypted
+// error, as their files can only be read one at a time with a Reader.
 func ReadArchive(r io.Reader) (*Archive, error) {
 	header, err := ReadHeader(r)
 	if err != nil {
 		return nil, err
 	}
 
+	if header.Flags&FlagEncryptedEntries != 0 {
+		return nil, fmt.Errorf("%w: its files can't be read into memory", ErrEncrypted)
+	}
+
 	files, err := ReadFiles(r, header)
 	if err != nil {
 		return nil, err
@@ -69,8 +71,16 @@ func ReadArchive(r io.Reader) (*Archive, error) {
 }
 
 // Create creates a new archive from the provided file paths.
+// Directories are walked recursively, adding an entry for each of the directories
+// (including empty ones) and files in them. Symbolic links and other files that aren't
+// regular files or directories are skipped.
 func Create(filePaths []string) (*Archive, error) {
-	files, err := readFiles(filePaths)
+	walkedPaths, err := walkPaths(filePaths, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := readFiles(walkedPaths)
 	if err != nil {
 		return nil, err
 	}
@@ -86,6 +96,52 @@ func Create(filePaths []string) (*Archive, error) {
 	}, nil
 }
 
+// walkPaths returns the provided paths, where each directory is followed by the paths
+// of all the directories and files inside it, in lexical order.
+// Symbolic links, which aren't followed, and other files that aren't regular files or
+// directories are skipped, calling onSkip with their path, if set.
+// Paths with the same entry name as a previous one, like a file inside a directory
+// that's also provided, are only returned once.
+func walkPaths(paths []string, onSkip func(path string)) ([]string, error) {
+	var (
+		walkedPaths []string
+		names       = make(map[string]bool)
+	)
+
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !d.IsDir() && !d.Type().IsRegular() {
+				if onSkip != nil {
+					onSkip(path)
+				}
+				return nil
+			}
+
+			name, err := entryName(path, d.IsDir())
+			if err != nil {
+				return err
+			}
+
+			if !names[name] {
+				walkedPaths = append(walkedPaths, path)
+				names[name] = true
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return walkedPaths, nil
+}
+
 // readFiles reads the files concurrently from the provided file paths.
 // Each file is xz-compressed and stored in an ArchiveFile struct.
 // The order of the files is preserved.
@@ -121,33 +177,35 @@ func readFiles(filePaths []string) ([]*ArchiveFile, error) {
 }
 
 func makeHeader(files []*ArchiveFile) (*Header, error) {
-	var (
-		entries           = make([]*HeaderFileEntry, len(files))
-		totalBytes uint32 = 8
-	)
+	entries := make([]*HeaderFileEntry, len(files))
 
 	for i, file := range files {
 		entries[i] = NewHeaderFileEntry(file.FileName, file.CompressedSize())
-		totalBytes += entries[i].totalBytes()
+		entries[i].Metadata = file.Metadata
+		entries[i].Checksum = file.Checksum
+		entries[i].UncompressedSize = file.UncompressedSize
+		entries[i].Method = file.Method
+		entries[i].Level = file.Level
+		entries[i].DictSize = file.DictSize
 	}
 
-	currentOffset := totalBytes + 1
+	header := newHeader(entries)
+
+	currentOffset := header.HeaderLength + 1
 	for _, entry := range entries {
 		entry.Offset = currentOffset
 		currentOffset += entry.Size
 	}
 
-	return &Header{
-		HeaderLength: totalBytes,
-		Entries:      entries,
-	}, nil
+	return header, nil
 }
 
 // ReadFileByName reads the archive's header until the name of the file is found.
 // Then, it reads the file's data and returns an ArchiveFile struct.
 // If the file is not found, it returns an ErrEntryNotFoundInHeader error.
-func ReadFileByName(r ReaderSeeker, fileName string) (*ArchiveFile, error) {
-	if fileHeaderEntry, err := FindHeaderEntryByName(r, fileName); err != nil {
+// Archives with encrypted entries are decrypted with the identities.
+func ReadFileByName(r ReaderSeeker, fileName string, identities ...Identity) (*ArchiveFile, error) {
+	if fileHeaderEntry, err := FindHeaderEntryByName(r, fileName, identities...); err != nil {
 		return nil, err
 	} else {
 		return fileHeaderEntry.ReadFrom(r)
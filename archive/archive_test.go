@@ -2,6 +2,7 @@ package archive
 
 import (
 	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -14,12 +15,18 @@ func TestCreateArchive(t *testing.T) {
 		fileOne      = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
 		fileTwo      = createTempFileForTest(t, "fileTwo.txt", "BBBBBBBB")
 		archive, err = Create([]string{fileOne.FileName, fileTwo.FileName})
+		nameOne      = entryNameForTest(t, fileOne.FileName)
+		nameTwo      = entryNameForTest(t, fileTwo.FileName)
 
-		wantHeaderLen = uint32(8 + (2 + len(fileOne.FileName) + 8) + (2 + len(fileTwo.FileName) + 8))
+		wantHeaderLen = uint64(18 + (2 + len(nameOne) + 16 + 20 + 32 + 8 + 1 + 5) + (2 + len(nameTwo) + 16 + 20 + 32 + 8 + 1 + 5))
 	)
 
 	assert.Nil(t, err)
 
+	t.Run("archive header version", func(t *testing.T) {
+		assert.Equal(t, CurrentFormatVersion, archive.Header.Version)
+	})
+
 	t.Run("archive header length", func(t *testing.T) {
 		assert.Equal(t, wantHeaderLen, archive.Header.HeaderLength)
 	})
@@ -27,9 +34,13 @@ func TestCreateArchive(t *testing.T) {
 	t.Run("archive header first file entry", func(t *testing.T) {
 		got := archive.Header.Entries[0]
 		want := &HeaderFileEntry{
-			Name:   fileOne.FileName,
-			Offset: wantHeaderLen + 1,
-			Size:   fileOne.CompressedSize(),
+			Name:             nameOne,
+			Offset:           wantHeaderLen + 1,
+			Size:             fileOne.CompressedSize(),
+			Metadata:         fileOne.Metadata,
+			Checksum:         fileOne.Checksum,
+			UncompressedSize: fileOne.UncompressedSize,
+			DictSize:         fileOne.DictSize,
 		}
 
 		assert.Equal(t, want, got)
@@ -38,9 +49,13 @@ func TestCreateArchive(t *testing.T) {
 	t.Run("archive header second file entry", func(t *testing.T) {
 		got := archive.Header.Entries[1]
 		want := &HeaderFileEntry{
-			Name:   fileTwo.FileName,
-			Offset: wantHeaderLen + 1 + fileOne.CompressedSize(),
-			Size:   fileTwo.CompressedSize(),
+			Name:             nameTwo,
+			Offset:           wantHeaderLen + 1 + fileOne.CompressedSize(),
+			Size:             fileTwo.CompressedSize(),
+			Metadata:         fileTwo.Metadata,
+			Checksum:         fileTwo.Checksum,
+			UncompressedSize: fileTwo.UncompressedSize,
+			DictSize:         fileTwo.DictSize,
 		}
 
 		assert.Equal(t, want, got)
@@ -63,6 +78,64 @@ func TestCreateArchive(t *testing.T) {
 	})
 }
 
+func TestCreateArchiveFromDirectory(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "src")
+	for _, dir := range []string{"sub", "empty"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			t.Fatalf("Error creating temp dir: %v", err)
+		}
+	}
+	for name, content := range map[string]string{"a.txt": "AAAA", "sub/b.txt": "BBBB"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Error creating temp file: %v", err)
+		}
+	}
+
+	archive, err := Create([]string{root})
+	assert.Nil(t, err)
+
+	t.Run("entry names", func(t *testing.T) {
+		var (
+			base = entryNameForTest(t, root)
+			want = []string{
+				base + "/",
+				base + "/a.txt",
+				base + "/empty/",
+				base + "/sub/",
+				base + "/sub/b.txt",
+			}
+			got = make([]string, len(archive.Header.Entries))
+		)
+
+		for i, entry := range archive.Header.Entries {
+			got[i] = entry.Name
+		}
+
+		assert.Equal(t, want, got)
+	})
+
+	t.Run("directories have no data", func(t *testing.T) {
+		for _, entry := range archive.Header.Entries {
+			if entry.IsDir() {
+				assert.Equal(t, uint64(0), entry.Size)
+			}
+		}
+	})
+
+	t.Run("nested file", func(t *testing.T) {
+		got, err := archive.Files[4].DecompressedBytes()
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("BBBB"), got)
+	})
+
+	t.Run("overlapping paths", func(t *testing.T) {
+		got, err := Create([]string{root, filepath.Join(root, "sub", "b.txt"), root + "/./a.txt"})
+
+		assert.Nil(t, err)
+		assert.Equal(t, archive.Header.Entries, got.Header.Entries)
+	})
+}
+
 func TestWriteAndReadArchive(t *testing.T) {
 	var (
 		fileOne    = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
@@ -93,24 +166,60 @@ func TestReadFileByName(t *testing.T) {
 	t.Run("read fileOne", func(t *testing.T) {
 		var (
 			reader = bytes.NewReader(arBytes.Bytes())
-			got, _ = ReadFileByName(reader, fileOne.FileName)
+			name   = entryNameForTest(t, fileOne.FileName)
+			got, _ = ReadFileByName(reader, name)
 		)
 
-		assert.Equal(t, fileOne, got)
+		assert.Equal(t, name, got.FileName)
+		assert.Equal(t, fileOne.CompressedBytes, got.CompressedBytes)
+		assert.Equal(t, fileOne.Checksum, got.Checksum)
 	})
 
 	t.Run("read fileTwo", func(t *testing.T) {
 		var (
 			reader = bytes.NewReader(arBytes.Bytes())
-			got, _ = ReadFileByName(reader, fileTwo.FileName)
+			name   = entryNameForTest(t, fileTwo.FileName)
+			got, _ = ReadFileByName(reader, name)
 		)
 
-		assert.Equal(t, fileTwo, got)
+		assert.Equal(t, name, got.FileName)
+		assert.Equal(t, fileTwo.CompressedBytes, got.CompressedBytes)
+		assert.Equal(t, fileTwo.Checksum, got.Checksum)
 	})
 }
 
+func TestReadArchiveWithOversizedEntry(t *testing.T) {
+	entry := NewHeaderFileEntry("huge.txt", 1<<62)
+	header := newHeader([]*HeaderFileEntry{entry})
+	entry.Offset = header.HeaderLength + 1
+
+	var arBytes bytes.Buffer
+	assert.Nil(t, header.Write(&arBytes))
+	arBytes.WriteString("not that huge")
+
+	t.Run("read archive", func(t *testing.T) {
+		_, err := ReadArchive(bytes.NewReader(arBytes.Bytes()))
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+
+	t.Run("read file by name", func(t *testing.T) {
+		_, err := ReadFileByName(bytes.NewReader(arBytes.Bytes()), "huge.txt")
+		assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	})
+}
+
+// entryNameForTest returns the name under which the file at path is archived.
+func entryNameForTest(t *testing.T, path string) string {
+	name, err := entryName(path, false)
+	if err != nil {
+		t.Fatalf("Error computing entry name: %v", err)
+	}
+
+	return name
+}
+
 // createTempFileForTest creates a file in the test's temporal directory and returns
-// an ArchiveFile with the file's name and expected compressed bytes.
+// an ArchiveFile with the file's name, metadata, checksum and expected compressed bytes.
 func createTempFileForTest(t *testing.T, fileName, content string) *ArchiveFile {
 	filePath := filepath.Join(t.TempDir(), fileName)
 
@@ -123,5 +232,16 @@ func createTempFileForTest(t *testing.T, fileName, content string) *ArchiveFile
 		t.Fatalf("Error compressing file: %v", err)
 	}
 
-	return NewFileFromCompressedBytes(filePath, compressedBytes)
+	info, err := os.Stat(filePath)
+	if err != nil {
+		t.Fatalf("Error reading temp file info: %v", err)
+	}
+
+	file := NewFileFromCompressedBytes(filePath, compressedBytes)
+	file.Metadata = metadataFromFileInfo(info)
+	file.Checksum = NewChecksum([]byte(content))
+	file.UncompressedSize = uint64(len(content))
+	file.DictSize = 8 << 20
+
+	return file
 }
@@ -0,0 +1,52 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// FlagChecksum is set in archives whose entries store a checksum of the files'
+// uncompressed data.
+const FlagChecksum Flags = 1 << 1
+
+// ErrChecksumMismatch is returned when the checksum of a file's uncompressed data
+// doesn't match the one stored in the archive, meaning that the archive is damaged.
+var ErrChecksumMismatch = fmt.Errorf("checksum mismatch")
+
+// A Checksum is the SHA-256 hash of a file's uncompressed data.
+// Its zero value means that the checksum is unknown.
+type Checksum [sha256.Size]byte
+
+// NewChecksum returns the checksum of the provided data.
+func NewChecksum(data []byte) Checksum {
+	return sha256.Sum256(data)
+}
+
+// IsZero returns true if the checksum is unknown.
+func (c Checksum) IsZero() bool {
+	return c == Checksum{}
+}
+
+// String returns the checksum as a hexadecimal string.
+func (c Checksum) String() string {
+	return hex.EncodeToString(c[:])
+}
+
+// verify returns an ErrChecksumMismatch error if the checksum is known and doesn't
+// match the checksum computed for the named file's data.
+func (c Checksum) verify(name string, got Checksum) error {
+	if c.IsZero() || c == got {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s", ErrChecksumMismatch, name)
+}
+
+// readChecksum reads a serialized checksum from the provided reader.
+func readChecksum(r io.Reader) (Checksum, error) {
+	var c Checksum
+	_, err := io.ReadFull(r, c[:])
+	return c, err
+}
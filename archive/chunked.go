@@ -0,0 +1,191 @@
+package archive
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// ErrDecryptionFailed is returned when encrypted data can't be authenticated, because
+// the password is wrong, or the data is corrupted or truncated.
+var ErrDecryptionFailed = fmt.Errorf("decryption failed: wrong password, or corrupted or truncated data")
+
+const (
+	// defaultChunkSize is the size of the plaintext chunks sealed separately.
+	defaultChunkSize = 64 << 10
+	// maxChunkSize caps the chunk size read from an encrypted archive, so that a
+	// crafted archive can't exhaust the memory when decrypting it.
+	maxChunkSize = 16 << 20
+	// noncePrefixSize is the size of the random part of the chunks' nonces. The rest of
+	// the nonce is the chunk's counter (4 bytes) and the final chunk flag (1 byte).
+	noncePrefixSize = nonceSize - 4 - 1
+)
+
+// chunkNonce returns the nonce of the chunk with the given counter: the prefix, followed
+// by the big-endian counter and 1 for the final chunk or 0 for the rest.
+func chunkNonce(nonce, prefix []byte, counter uint32, final bool) []byte {
+	nonce = append(nonce[:0], prefix...)
+	nonce = binary.BigEndian.AppendUint32(nonce, counter)
+	if final {
+		return append(nonce, 1)
+	}
+
+	return append(nonce, 0)
+}
+
+// A chunkWriter encrypts the data written to it in fixed-size chunks, each sealed with
+// its own nonce, so that data of any size is encrypted in constant memory. The last
+// chunk is flagged in its nonce, so that a truncated stream fails to decrypt.
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	prefix  []byte
+	ad      []byte
+	nonce   []byte
+	buf     []byte
+	sealed  []byte
+	counter uint32
+	closed  bool
+}
+
+// newChunkWriter returns a writer that encrypts the data written to it into w, using
+// the AEAD, the nonce prefix and chunks of the given size, authenticating the additional
+// data, if any, along with each chunk. It must be closed to write the final chunk.
+func newChunkWriter(w io.Writer, aead cipher.AEAD, prefix, ad []byte, chunkSize int) *chunkWriter {
+	return &chunkWriter{
+		w:      w,
+		aead:   aead,
+		prefix: prefix,
+		ad:     ad,
+		nonce:  make([]byte, 0, nonceSize),
+		buf:    make([]byte, 0, chunkSize),
+		sealed: make([]byte, 0, chunkSize+aead.Overhead()),
+	}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, fmt.Errorf("write to closed encryption writer")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// A full chunk is only sealed once more data comes, as it may be the final one
+		if len(c.buf) == cap(c.buf) {
+			if err := c.seal(false); err != nil {
+				return written, err
+			}
+		}
+
+		n := copy(c.buf[len(c.buf):cap(c.buf)], p)
+		c.buf = c.buf[:len(c.buf)+n]
+		p = p[n:]
+		written += n
+	}
+
+	return written, nil
+}
+
+// Close seals the final chunk, which may be empty. It doesn't close the underlying writer.
+func (c *chunkWriter) Close() error {
+	if c.closed {
+		return nil
+	}
+
+	c.closed = true
+	return c.seal(true)
+}
+
+// seal encrypts the buffered chunk and writes it.
+func (c *chunkWriter) seal(final bool) error {
+	if c.counter == math.MaxUint32 {
+		return fmt.Errorf("too much data to encrypt")
+	}
+
+	nonce := chunkNonce(c.nonce, c.prefix, c.counter, final)
+	c.sealed = c.aead.Seal(c.sealed[:0], nonce, c.buf, c.ad)
+	c.buf = c.buf[:0]
+	c.counter++
+
+	_, err := c.w.Write(c.sealed)
+	return err
+}
+
+// A chunkReader decrypts the data written by a chunkWriter, one chunk at a time.
+type chunkReader struct {
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	ad      []byte
+	nonce   []byte
+	buf     []byte
+	plain   []byte
+	counter uint32
+	done    bool
+}
+
+// newChunkReader returns a reader that decrypts the chunks read from r, using the AEAD,
+// the nonce prefix, the additional data and the chunk size they were encrypted with.
+// Reading returns an ErrDecryptionFailed error if a chunk can't be authenticated, or if
+// the stream ends before the final chunk.
+func newChunkReader(r io.Reader, aead cipher.AEAD, prefix, ad []byte, chunkSize int) *chunkReader {
+	return &chunkReader{
+		r:      bufio.NewReader(r),
+		aead:   aead,
+		prefix: prefix,
+		ad:     ad,
+		nonce:  make([]byte, 0, nonceSize),
+		buf:    make([]byte, chunkSize+aead.Overhead()),
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+	return n, nil
+}
+
+// open reads and decrypts the next chunk. A chunk is the final one if it's shorter than
+// a full chunk, or if nothing follows it.
+func (c *chunkReader) open() error {
+	n, err := io.ReadFull(c.r, c.buf)
+	switch {
+	case err == io.ErrUnexpectedEOF || err == io.EOF:
+		c.done = true
+	case err != nil:
+		return err
+	default:
+		if _, err := c.r.Peek(1); err == io.EOF {
+			c.done = true
+		} else if err != nil {
+			return err
+		}
+	}
+
+	if n < c.aead.Overhead() {
+		return fmt.Errorf("%w: chunk %d is truncated", ErrDecryptionFailed, c.counter)
+	}
+
+	nonce := chunkNonce(c.nonce, c.prefix, c.counter, c.done)
+	plain, err := c.aead.Open(c.buf[:0], nonce, c.buf[:n], c.ad)
+	if err != nil {
+		return fmt.Errorf("%w: chunk %d", ErrDecryptionFailed, c.counter)
+	}
+
+	c.plain = plain
+	c.counter++
+	return nil
+}
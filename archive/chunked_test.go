@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChunkedEncryption(t *testing.T) {
+	const chunkSize = 16
+
+	var (
+		block, _  = aes.NewCipher(bytes.Repeat([]byte{0x01}, 32))
+		aesGCM, _ = cipher.NewGCM(block)
+		prefix    = bytes.Repeat([]byte{0x02}, noncePrefixSize)
+		overhead  = aesGCM.Overhead()
+	)
+
+	encrypt := func(t *testing.T, data []byte) []byte {
+		var sealed bytes.Buffer
+
+		w := newChunkWriter(&sealed, aesGCM, prefix, nil, chunkSize)
+		for len(data) > 0 {
+			// Write in uneven pieces, across the chunk boundaries
+			n := min(len(data), 7)
+			_, err := w.Write(data[:n])
+			assert.Nil(t, err)
+			data = data[n:]
+		}
+		assert.Nil(t, w.Close())
+
+		return sealed.Bytes()
+	}
+
+	decrypt := func(sealed []byte) ([]byte, error) {
+		return io.ReadAll(newChunkReader(bytes.NewReader(sealed), aesGCM, prefix, nil, chunkSize))
+	}
+
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		data := bytes.Repeat([]byte{'A'}, size)
+		sealed := encrypt(t, data)
+
+		chunks := max((size+chunkSize-1)/chunkSize, 1)
+		assert.Equal(t, size+chunks*overhead, len(sealed))
+
+		got, err := decrypt(sealed)
+		assert.Nil(t, err)
+		assert.Equal(t, data, append([]byte{}, got...))
+	}
+
+	sealed := encrypt(t, bytes.Repeat([]byte{'A'}, 3*chunkSize))
+	fullChunk := chunkSize + overhead
+
+	t.Run("truncated at a chunk boundary", func(t *testing.T) {
+		_, err := decrypt(sealed[:2*fullChunk])
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("truncated inside a chunk", func(t *testing.T) {
+		_, err := decrypt(sealed[:len(sealed)-1])
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		_, err := decrypt(nil)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("reordered chunks", func(t *testing.T) {
+		reordered := append([]byte{}, sealed[fullChunk:2*fullChunk]...)
+		reordered = append(reordered, sealed[:fullChunk]...)
+		reordered = append(reordered, sealed[2*fullChunk:]...)
+
+		_, err := decrypt(reordered)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		tampered := append([]byte{}, sealed...)
+		tampered[fullChunk] ^= 0xFF
+
+		_, err := decrypt(tampered)
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+}
@@ -0,0 +1,290 @@
+package archive
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// FlagCompressionMethod is set in archives whose entries store the method used to
+// compress them. Entries in archives without it are compressed with MethodXZ.
+const FlagCompressionMethod Flags = 1 << 3
+
+// FlagCompressionOptions is set in archives whose entries store the compression level
+// and xz dictionary size used to compress them.
+const FlagCompressionOptions Flags = 1 << 4
+
+// ErrUnknownCompressionMethod is returned when using a compression method without a
+// registered codec.
+var ErrUnknownCompressionMethod = fmt.Errorf("unknown compression method")
+
+// A CompressionMethod identifies the algorithm used to compress an entry's data.
+// It's stored in the archive using 1 byte.
+type CompressionMethod uint8
+
+const (
+	// MethodXZ compresses the data using xz. It's the default method, and the only
+	// one available in archives without FlagCompressionMethod.
+	MethodXZ CompressionMethod = 0
+	// MethodStore stores the data uncompressed, for data that's already compressed.
+	MethodStore CompressionMethod = 1
+	// MethodDeflate compresses the data using raw DEFLATE.
+	MethodDeflate CompressionMethod = 2
+	// MethodGzip compresses the data using gzip.
+	MethodGzip CompressionMethod = 3
+	// MethodZlib compresses the data using zlib.
+	MethodZlib CompressionMethod = 4
+)
+
+// ErrInvalidCompressionLevel is returned when using a preset outside the 0 to 9 range.
+var ErrInvalidCompressionLevel = fmt.Errorf("invalid compression level")
+
+// ErrInvalidDictSize is returned when using a dictionary size with a method other than
+// MethodXZ, or one smaller than the xz minimum of 4 KiB.
+var ErrInvalidDictSize = fmt.Errorf("invalid dictionary size")
+
+// A CompressionLevel selects a compression preset, trading speed for ratio, like the
+// -0 to -9 options of xz and gzip. It's stored in the archive using 1 byte, which is
+// 0 for DefaultLevel and the preset plus one otherwise.
+type CompressionLevel uint8
+
+// DefaultLevel uses the default preset of each compression method.
+const DefaultLevel CompressionLevel = 0
+
+// Preset returns the compression level of the preset, from 0 (fastest) to 9 (smallest),
+// or an ErrInvalidCompressionLevel error if it's out of range.
+func Preset(preset int) (CompressionLevel, error) {
+	if preset < 0 || preset > 9 {
+		return 0, fmt.Errorf("%w: %d", ErrInvalidCompressionLevel, preset)
+	}
+
+	return CompressionLevel(preset + 1), nil
+}
+
+// Preset returns the level's preset, or false for DefaultLevel.
+func (l CompressionLevel) Preset() (int, bool) {
+	if l == DefaultLevel {
+		return 0, false
+	}
+
+	return int(l) - 1, true
+}
+
+// String returns the level as its preset option, like "-9", or "default".
+func (l CompressionLevel) String() string {
+	if preset, ok := l.Preset(); ok {
+		return fmt.Sprintf("-%d", preset)
+	}
+
+	return "default"
+}
+
+// CompressorOptions tune how a Compressor compresses the data.
+type CompressorOptions struct {
+	Level CompressionLevel
+	// DictSize is the xz dictionary size in bytes, or 0 for the level's default.
+	// Other methods ignore it.
+	DictSize uint32
+}
+
+// A Compressor returns a writer that compresses the data written to it into w, using
+// the options. Closing the writer must flush the compressed data, but not close w.
+type Compressor func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error)
+
+// A Decompressor returns a reader that decompresses the data read from r.
+type Decompressor func(r io.Reader) (io.ReadCloser, error)
+
+// A Codec compresses and decompresses data using a compression method.
+type Codec struct {
+	// Name identifies the method in the command line and listings.
+	Name         string
+	Compressor   Compressor
+	Decompressor Decompressor
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[CompressionMethod]Codec{}
+)
+
+func init() {
+	RegisterCodec(MethodXZ, Codec{
+		Name: "xz",
+		Compressor: func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error) {
+			config := xz.WriterConfig{DictCap: int(xzDictSize(opts))}
+			return config.NewWriter(w)
+		},
+		Decompressor: func(r io.Reader) (io.ReadCloser, error) {
+			xzReader, err := xz.NewReader(r)
+			if err != nil {
+				return nil, err
+			}
+
+			return io.NopCloser(xzReader), nil
+		},
+	})
+
+	RegisterCodec(MethodStore, Codec{
+		Name: "store",
+		Compressor: func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error) {
+			return nopWriteCloser{w}, nil
+		},
+		Decompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		},
+	})
+
+	RegisterCodec(MethodDeflate, Codec{
+		Name: "deflate",
+		Compressor: func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error) {
+			return flate.NewWriter(w, flateLevel(opts.Level))
+		},
+		Decompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	})
+
+	RegisterCodec(MethodGzip, Codec{
+		Name: "gzip",
+		Compressor: func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, flateLevel(opts.Level))
+		},
+		Decompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	})
+
+	RegisterCodec(MethodZlib, Codec{
+		Name: "zlib",
+		Compressor: func(w io.Writer, opts CompressorOptions) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, flateLevel(opts.Level))
+		},
+		Decompressor: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	})
+}
+
+// RegisterCodec registers the codec for the compression method, so that entries can be
+// compressed and decompressed with it. It panics if the method or the codec's name are
+// already registered.
+func RegisterCodec(method CompressionMethod, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[method]; ok {
+		panic(fmt.Sprintf("compression method %d already registered", method))
+	}
+
+	for _, registered := range codecs {
+		if registered.Name == codec.Name {
+			panic(fmt.Sprintf("compression method %q already registered", codec.Name))
+		}
+	}
+
+	codecs[method] = codec
+}
+
+// codecFor returns the codec registered for the compression method, or an
+// ErrUnknownCompressionMethod error if there's none.
+func codecFor(method CompressionMethod) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	codec, ok := codecs[method]
+	if !ok {
+		return Codec{}, fmt.Errorf("%w: %d", ErrUnknownCompressionMethod, method)
+	}
+
+	return codec, nil
+}
+
+// ParseCompressionMethod returns the compression method whose codec has the given
+// name, or an ErrUnknownCompressionMethod error if there's none.
+func ParseCompressionMethod(name string) (CompressionMethod, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for method, codec := range codecs {
+		if codec.Name == name {
+			return method, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownCompressionMethod, name)
+}
+
+// String returns the name of the method's codec.
+func (m CompressionMethod) String() string {
+	if codec, err := codecFor(m); err == nil {
+		return codec.Name
+	}
+
+	return fmt.Sprintf("CompressionMethod(%d)", uint8(m))
+}
+
+// xzPresetDictSizes are the dictionary sizes of the xz presets, as in the xz tool.
+// Unlike in the xz tool, the presets don't change the match finder: the binary tree one
+// of the xz package is too slow to be used.
+var xzPresetDictSizes = [10]uint32{
+	256 << 10, 1 << 20, 2 << 20, 4 << 20, 4 << 20, 8 << 20, 8 << 20, 16 << 20, 32 << 20, 64 << 20,
+}
+
+// xzDictSize returns the xz dictionary size for the options: the given one, if any, or
+// the one of the level's preset, which is 8 MiB for DefaultLevel.
+func xzDictSize(opts CompressorOptions) uint32 {
+	if opts.DictSize != 0 {
+		return opts.DictSize
+	}
+
+	if preset, ok := opts.Level.Preset(); ok {
+		return xzPresetDictSizes[preset]
+	}
+
+	return xzPresetDictSizes[6]
+}
+
+// checkCompressorOptions returns an ErrInvalidDictSize error if the options set a
+// dictionary size that the compression method can't use.
+func checkCompressorOptions(method CompressionMethod, opts CompressorOptions) error {
+	if opts.DictSize == 0 {
+		return nil
+	}
+
+	if method != MethodXZ {
+		return fmt.Errorf("%w: only xz uses a dictionary", ErrInvalidDictSize)
+	}
+
+	if opts.DictSize < lzma.MinDictCap {
+		return fmt.Errorf("%w: %d bytes (minimum is %d)", ErrInvalidDictSize, opts.DictSize, lzma.MinDictCap)
+	}
+
+	return nil
+}
+
+// flateLevel returns the DEFLATE level for the compression level. Preset 0 uses the
+// fastest level instead of storing the data uncompressed, which is what MethodStore
+// is for.
+func flateLevel(level CompressionLevel) int {
+	preset, ok := level.Preset()
+	if !ok {
+		return flate.DefaultCompression
+	}
+
+	return max(preset, flate.BestSpeed)
+}
+
+// nopWriteCloser is a writer whose Close method does nothing.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
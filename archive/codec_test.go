@@ -0,0 +1,141 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressionMethods(t *testing.T) {
+	content := strings.Repeat("compressible data ", 100)
+
+	for _, method := range []CompressionMethod{MethodXZ, MethodStore, MethodDeflate, MethodGzip, MethodZlib} {
+		t.Run(method.String(), func(t *testing.T) {
+			outFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+			if err != nil {
+				t.Fatalf("Error creating output file: %v", err)
+			}
+			defer outFile.Close()
+
+			header, err := CreateStreamFrom(outFile, nil, []*NamedReader{
+				{Name: "data.txt", Reader: strings.NewReader(content)},
+			}, CreateOptions{Compression: method})
+			assert.Nil(t, err)
+			assert.Equal(t, method, header.Entries[0].Method)
+
+			reader, err := NewReader(outFile)
+			assert.Nil(t, err)
+			assert.Equal(t, method, reader.Header.Entries[0].Method)
+
+			rc, err := reader.Open("data.txt")
+			assert.Nil(t, err)
+			defer rc.Close()
+
+			data, err := io.ReadAll(rc)
+			assert.Nil(t, err)
+			assert.Equal(t, content, string(data))
+		})
+	}
+
+	t.Run("unknown method", func(t *testing.T) {
+		outFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		if err != nil {
+			t.Fatalf("Error creating output file: %v", err)
+		}
+		defer outFile.Close()
+
+		_, err = CreateStreamFrom(outFile, nil, nil, CreateOptions{Compression: 200})
+		assert.ErrorIs(t, err, ErrUnknownCompressionMethod)
+	})
+}
+
+func TestCompressionOptions(t *testing.T) {
+	content := strings.Repeat("compressible data ", 100)
+
+	create := func(t *testing.T, opts CreateOptions) (*Header, error) {
+		outFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		if err != nil {
+			t.Fatalf("Error creating output file: %v", err)
+		}
+		t.Cleanup(func() { outFile.Close() })
+
+		return CreateStreamFrom(outFile, nil, []*NamedReader{
+			{Name: "data.txt", Reader: strings.NewReader(content)},
+		}, opts)
+	}
+
+	t.Run("xz preset", func(t *testing.T) {
+		level, _ := Preset(9)
+		header, err := create(t, CreateOptions{Level: level})
+
+		assert.Nil(t, err)
+		assert.Equal(t, level, header.Entries[0].Level)
+		assert.Equal(t, uint32(64<<20), header.Entries[0].DictSize)
+	})
+
+	t.Run("xz dictionary size", func(t *testing.T) {
+		level, _ := Preset(0)
+		header, err := create(t, CreateOptions{Level: level, DictSize: 1 << 20})
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint32(1<<20), header.Entries[0].DictSize)
+	})
+
+	t.Run("gzip preset", func(t *testing.T) {
+		level, _ := Preset(1)
+		header, err := create(t, CreateOptions{Compression: MethodGzip, Level: level})
+
+		assert.Nil(t, err)
+		assert.Equal(t, level, header.Entries[0].Level)
+		assert.Equal(t, uint32(0), header.Entries[0].DictSize)
+	})
+
+	t.Run("dictionary size without xz", func(t *testing.T) {
+		_, err := create(t, CreateOptions{Compression: MethodGzip, DictSize: 1 << 20})
+		assert.ErrorIs(t, err, ErrInvalidDictSize)
+	})
+
+	t.Run("dictionary size too small", func(t *testing.T) {
+		_, err := create(t, CreateOptions{DictSize: 1024})
+		assert.ErrorIs(t, err, ErrInvalidDictSize)
+	})
+}
+
+func TestPreset(t *testing.T) {
+	for preset := 0; preset <= 9; preset++ {
+		level, err := Preset(preset)
+		assert.Nil(t, err)
+
+		got, ok := level.Preset()
+		assert.True(t, ok)
+		assert.Equal(t, preset, got)
+	}
+
+	_, ok := DefaultLevel.Preset()
+	assert.False(t, ok)
+	assert.Equal(t, "default", DefaultLevel.String())
+
+	_, err := Preset(10)
+	assert.ErrorIs(t, err, ErrInvalidCompressionLevel)
+}
+
+func TestParseCompressionMethod(t *testing.T) {
+	for _, method := range []CompressionMethod{MethodXZ, MethodStore, MethodDeflate, MethodGzip, MethodZlib} {
+		got, err := ParseCompressionMethod(method.String())
+
+		assert.Nil(t, err)
+		assert.Equal(t, method, got)
+	}
+
+	_, err := ParseCompressionMethod("lzma")
+	assert.ErrorIs(t, err, ErrUnknownCompressionMethod)
+}
+
+func TestRegisterCodec(t *testing.T) {
+	assert.Panics(t, func() { RegisterCodec(MethodXZ, Codec{Name: "other"}) })
+	assert.Panics(t, func() { RegisterCodec(200, Codec{Name: "xz"}) })
+}
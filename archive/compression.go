@@ -3,16 +3,26 @@ package archive
 import (
 	"bytes"
 	"io"
-
-	"github.com/ulikunitz/xz"
 )
 
+// newCompressor returns a writer that compresses the data written to it using the
+// compression method and options, and writes it into w. It must be closed to flush the
+// compressed data.
+func newCompressor(w io.Writer, method CompressionMethod, opts CompressorOptions) (io.WriteCloser, error) {
+	codec, err := codecFor(method)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Compressor(w, opts)
+}
+
 // Compress compresses the given bytes using the xz algorithm and returns the
 // compressed bytes.
 func Compress(data []byte) ([]byte, error) {
 	var (
 		compressedData bytes.Buffer
-		xzWriter, err  = xz.NewWriter(&compressedData)
+		xzWriter, err  = newCompressor(&compressedData, MethodXZ, CompressorOptions{})
 	)
 
 	if err != nil {
@@ -32,17 +42,42 @@ func Compress(data []byte) ([]byte, error) {
 	return compressedData.Bytes(), nil
 }
 
+// newDecompressor returns a reader that decompresses the data read from r, compressed
+// using the compression method.
+func newDecompressor(r io.Reader, method CompressionMethod) (io.ReadCloser, error) {
+	codec, err := codecFor(method)
+	if err != nil {
+		return nil, err
+	}
+
+	return codec.Decompressor(r)
+}
+
+// maxPreallocSize caps the buffer preallocated for the uncompressed data, so that
+// a corrupt or crafted uncompressed size can't exhaust the memory.
+const maxPreallocSize = 1 << 30
+
 // Decompress decompresses the given bytes using the xz algorithm and returns the
 // uncompressed bytes.
 func Decompress(data []byte) ([]byte, error) {
-	var (
-		reader        = bytes.NewReader(data)
-		xzReader, err = xz.NewReader(reader)
-	)
+	return decompress(data, MethodXZ, 0)
+}
 
+// decompress is like Decompress, but it uses the given compression method and
+// preallocates the buffer for the uncompressed data with the given size, if known.
+func decompress(data []byte, method CompressionMethod, size uint64) ([]byte, error) {
+	decompressor, err := newDecompressor(bytes.NewReader(data), method)
 	if err != nil {
 		return nil, err
 	}
+	defer decompressor.Close()
+
+	var uncompressedData bytes.Buffer
+	uncompressedData.Grow(int(min(size, maxPreallocSize)) + bytes.MinRead)
+
+	if _, err := uncompressedData.ReadFrom(decompressor); err != nil {
+		return nil, err
+	}
 
-	return io.ReadAll(xzReader)
+	return uncompressedData.Bytes(), nil
 }
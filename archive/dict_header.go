@@ -1,7 +1,6 @@
 package archive
 
 import (
-	"encoding/binary"
 	"io"
 )
 
@@ -9,42 +8,39 @@ import (
 // dictionary format, by name. Use this version to extract files by name.
 // This header shouldn't be used for writing to the archive file.
 type DictHeader struct {
-	HeaderLength uint32
+	Version      FormatVersion
+	Flags        Flags
+	HeaderLength uint64
 	Entries      map[string]*HeaderFileEntry
 }
 
-func ReadDictHeader(r io.Reader) (*DictHeader, error) {
-	var (
-		headerLength uint32
-		readBytes    uint32 = 0
-		fileEntries         = make(map[string]*HeaderFileEntry)
-	)
+func ReadDictHeader(r io.Reader, identities ...Identity) (*DictHeader, error) {
+	fileEntries := make(map[string]*HeaderFileEntry)
 
-	if err := mustReadMagic(r); err != nil {
+	header, readBytes, err := readPreamble(r)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += magicLen
 	}
 
-	// Read the header length (4 bytes)
-	if err := binary.Read(r, byteOrder, &headerLength); err != nil {
+	entries, entriesLength, err := header.openEntries(r, readBytes, identities)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += 4
 	}
 
-	for readBytes < headerLength {
-		entry, err := ReadHeaderFile(r)
+	for readBytes = 0; readBytes < entriesLength; {
+		entry, err := header.readEntry(entries)
 		if err != nil {
 			return nil, err
 		} else {
-			readBytes += entry.totalBytes()
+			readBytes += entry.totalBytes(header.Version, header.Flags)
 			fileEntries[entry.Name] = entry
 		}
 	}
 
 	return &DictHeader{
-		HeaderLength: headerLength,
+		Version:      header.Version,
+		Flags:        header.Flags,
+		HeaderLength: header.HeaderLength,
 		Entries:      fileEntries,
 	}, nil
 }
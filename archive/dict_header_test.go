@@ -23,8 +23,34 @@ func TestReadDictHeader(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	assert.Equal(t, header.HeaderLength, uint32(26))
+	assert.Equal(t, header.Version, FormatV1)
+	assert.Equal(t, header.HeaderLength, uint64(26))
 	assert.Equal(t, len(header.Entries), 1)
-	assert.Equal(t, header.Entries["test.txt"].Offset, uint32(27))
-	assert.Equal(t, header.Entries["test.txt"].Size, uint32(4))
+	assert.Equal(t, header.Entries["test.txt"].Offset, uint64(27))
+	assert.Equal(t, header.Entries["test.txt"].Size, uint64(4))
+}
+
+func TestReadDictHeaderV2(t *testing.T) {
+	data := []byte{
+		0x41, 0x41, 0x52, 0x56, // magic
+		0x02, 0x00, // version
+		0x00, 0x00, 0x00, 0x00, // flags
+		0x2C, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // header length
+		0x08, 0x00, // length of file name
+		't', 'e', 's', 't', '.', 't', 'x', 't', // file name
+		0x2D, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // offset
+		0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, // size
+	}
+	reader := bytes.NewReader(data)
+
+	header, err := ReadDictHeader(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	assert.Equal(t, header.Version, FormatV2)
+	assert.Equal(t, header.HeaderLength, uint64(44))
+	assert.Equal(t, len(header.Entries), 1)
+	assert.Equal(t, header.Entries["test.txt"].Offset, uint64(45))
+	assert.Equal(t, header.Entries["test.txt"].Size, uint64(1<<32+4))
 }
@@ -0,0 +1,9 @@
+//go:build !(linux || darwin || freebsd || dragonfly || windows)
+
+package archive
+
+// availableSpace reports that the available space is unknown, as it can't be queried
+// in this platform.
+func availableSpace(dir string) (uint64, bool, error) {
+	return 0, false, nil
+}
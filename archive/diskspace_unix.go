@@ -0,0 +1,16 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package archive
+
+import "golang.org/x/sys/unix"
+
+// availableSpace returns the bytes available to the user in the file system of the
+// existing directory at dir.
+func availableSpace(dir string) (uint64, bool, error) {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(dir, &stat); err != nil {
+		return 0, false, err
+	}
+
+	return uint64(stat.Bavail) * uint64(stat.Bsize), true, nil
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package archive
+
+import "golang.org/x/sys/windows"
+
+// availableSpace returns the bytes available to the user in the volume of the existing
+// directory at dir.
+func availableSpace(dir string) (uint64, bool, error) {
+	path, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, false, err
+	}
+
+	var available uint64
+	if err := windows.GetDiskFreeSpaceEx(path, &available, nil, nil); err != nil {
+		return 0, false, err
+	}
+
+	return available, true, nil
+}
@@ -0,0 +1,251 @@
+package archive
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrDuplicateEntry is returned when adding a file whose name is already in the archive.
+var ErrDuplicateEntry = fmt.Errorf("file already in the archive")
+
+// Append writes into ws a copy of the archive read by r with the files in the provided
+// paths added at the end. Directories are walked recursively, like in Create, skipping
+// the directories that are already in the archive, and calling onSkip, if set, with
+// the path of each skipped symbolic link. Adding a file that's already in the archive
+// returns an ErrDuplicateEntry error.
+//
+// The existing files' compressed data is copied as is, without recompressing it, and
+// only the new files are compressed. The copy is written in the current format
+// version, recomputing the offsets of all the files. It returns the copy's header.
+func Append(ws io.WriteSeeker, r *Reader, filePaths []string, onSkip func(path string)) (*Header, error) {
+	walkedPaths, err := walkPaths(filePaths, onSkip)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries  = copyEntries(r.Header.Entries)
+		newPaths = make(map[string]string)
+	)
+
+	for _, path := range walkedPaths {
+		entry, err := NewHeaderFileEntryFromPath(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := r.Entry(entry.Name); err == nil {
+			if entry.IsDir() {
+				continue
+			}
+
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateEntry, entry.Name)
+		}
+
+		if _, ok := newPaths[entry.Name]; ok {
+			continue
+		}
+
+		entries = append(entries, entry)
+		newPaths[entry.Name] = path
+	}
+
+	w, err := newWriterFor(ws, entries, r.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := copyRawEntries(w, r, r.Header.Entries); err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries[len(r.Header.Entries):] {
+		if entry.IsDir() {
+			continue
+		}
+
+		if err := writeFileFromPath(w, entry.Name, newPaths[entry.Name]); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.Header(), nil
+}
+
+// copyEntries returns copies of the entries, so that they can be modified without
+// altering the originals.
+func copyEntries(entries []*HeaderFileEntry) []*HeaderFileEntry {
+	copies := make([]*HeaderFileEntry, len(entries))
+	for i, entry := range entries {
+		entryCopy := *entry
+		copies[i] = &entryCopy
+	}
+
+	return copies
+}
+
+// copyRawEntries copies the compressed data of the entries, read by r, into w as is.
+// Directories have no data to copy.
+func copyRawEntries(w *Writer, r *Reader, entries []*HeaderFileEntry) error {
+	for _, entry := range entries {
+		if err := copyRawEntry(w, r, entry.Name, entry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// copyRawEntry copies the compressed data of the entry, read by r, into w as is, with
+// the given name. The uncompressed size is computed if the archive doesn't store it.
+// Directories have no data to copy. Encrypted data is encrypted again if renamed, as
+// its encryption authenticates the file's name.
+func copyRawEntry(w *Writer, r *Reader, name string, entry *HeaderFileEntry) error {
+	if entry.IsDir() {
+		return nil
+	}
+
+	src := *entry
+	size, err := r.UncompressedSize(entry)
+	if err != nil {
+		return err
+	}
+	src.UncompressedSize = size
+
+	return w.writeRaw(name, r.OpenRaw(entry), &src, entry.encryption != nil && name != entry.Name)
+}
+
+// ErrInvalidEntryName is returned when renaming an entry to a name that isn't valid for it.
+var ErrInvalidEntryName = fmt.Errorf("invalid entry name")
+
+// Remove writes into ws a copy of the archive read by r without the named entries.
+// Removing a directory also removes all the entries inside it. Removing an entry that
+// isn't in the archive returns an ErrEntryNotFoundInHeader error.
+//
+// The surviving files' compressed data is copied as is, compacting the data section.
+// It returns the copy's header.
+func Remove(ws io.WriteSeeker, r *Reader, names []string) (*Header, error) {
+	removed := make(map[string]bool, len(names))
+	for _, name := range names {
+		entry, err := findEntryOrDir(r, name)
+		if err != nil {
+			return nil, err
+		}
+
+		removed[entry.Name] = true
+	}
+
+	var kept []*HeaderFileEntry
+	for _, entry := range r.Header.Entries {
+		if !removed[entry.Name] && !isInRemovedDir(entry.Name, removed) {
+			kept = append(kept, entry)
+		}
+	}
+
+	w, err := newWriterFor(ws, copyEntries(kept), r.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := copyRawEntries(w, r, kept); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.Header(), nil
+}
+
+// Rename writes into ws a copy of the archive read by r with the entry named oldName
+// renamed to newName. Renaming a directory also renames all the entries inside it.
+// Renaming an entry that isn't in the archive returns an ErrEntryNotFoundInHeader
+// error, and renaming it to a name already in the archive, an ErrDuplicateEntry error.
+//
+// The files' compressed data is copied as is, except for the renamed files of archives
+// with encrypted entries, which are decrypted and encrypted again, without
+// recompressing them. It returns the copy's header.
+func Rename(ws io.WriteSeeker, r *Reader, oldName, newName string) (*Header, error) {
+	oldEntry, err := findEntryOrDir(r, oldName)
+	if err != nil {
+		return nil, err
+	}
+
+	if newName == "" || newName == "/" || isDirName(newName) && !oldEntry.IsDir() {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidEntryName, newName)
+	}
+
+	if oldEntry.IsDir() {
+		newName = dirName(newName)
+	}
+
+	var (
+		entries  = copyEntries(r.Header.Entries)
+		newNames = make(map[string]bool, len(entries))
+	)
+
+	for _, entry := range entries {
+		if entry.Name == oldEntry.Name {
+			entry.Name = newName
+		} else if oldEntry.IsDir() && strings.HasPrefix(entry.Name, oldEntry.Name) {
+			entry.Name = newName + strings.TrimPrefix(entry.Name, oldEntry.Name)
+		}
+	}
+
+	for _, entry := range entries {
+		if newNames[entry.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateEntry, entry.Name)
+		}
+
+		newNames[entry.Name] = true
+	}
+
+	w, err := newWriterFor(ws, entries, r.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range r.Header.Entries {
+		if err := copyRawEntry(w, r, entries[i].Name, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.Header(), nil
+}
+
+// findEntryOrDir returns the entry with the given name, or the directory entry with
+// the name if it's missing the trailing slash.
+func findEntryOrDir(r *Reader, name string) (*HeaderFileEntry, error) {
+	if entry, err := r.Entry(name); err == nil {
+		return entry, nil
+	}
+
+	if entry, err := r.Entry(dirName(name)); err == nil {
+		return entry, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrEntryNotFoundInHeader, name)
+}
+
+// isInRemovedDir returns whether the named entry is inside one of the removed
+// directories.
+func isInRemovedDir(name string, removed map[string]bool) bool {
+	for removedName := range removed {
+		if isDirName(removedName) && strings.HasPrefix(name, removedName) {
+			return true
+		}
+	}
+
+	return false
+}
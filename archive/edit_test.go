@@ -0,0 +1,167 @@
+package archive
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppend(t *testing.T) {
+	var (
+		reader = newTestReader(t, []testEntry{
+			{name: "dir/"},
+			{name: "dir/one.txt", content: "AAAAAAAA"},
+		})
+		newFile = createTempFileForTest(t, "two.txt", "BBBBBBBB")
+		outPath = filepath.Join(t.TempDir(), "out.aarch")
+	)
+
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	defer outFile.Close()
+
+	header, err := Append(outFile, reader, []string{newFile.FileName}, nil)
+	assert.Nil(t, err)
+
+	got, err := NewReader(outFile)
+	assert.Nil(t, err)
+
+	t.Run("header", func(t *testing.T) {
+		assert.Equal(t, header, got.Header)
+		assert.Equal(t, 3, len(got.Header.Entries))
+		assert.Equal(t, entryNameForTest(t, newFile.FileName), got.Header.Entries[2].Name)
+		assert.Equal(t, newFile.Metadata, got.Header.Entries[2].Metadata)
+	})
+
+	t.Run("existing file copied as is", func(t *testing.T) {
+		var (
+			oldEntry, _ = reader.Entry("dir/one.txt")
+			newEntry, _ = got.Entry("dir/one.txt")
+			want, _     = io.ReadAll(reader.OpenRaw(oldEntry))
+			data, _     = io.ReadAll(got.OpenRaw(newEntry))
+		)
+
+		assert.Equal(t, want, data)
+		assert.Equal(t, oldEntry.Checksum, newEntry.Checksum)
+	})
+
+	t.Run("new file", func(t *testing.T) {
+		rc, err := got.Open(entryNameForTest(t, newFile.FileName))
+		assert.Nil(t, err)
+		defer rc.Close()
+
+		data, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("BBBBBBBB"), data)
+	})
+
+	t.Run("duplicate file", func(t *testing.T) {
+		outFile, _ := os.Create(filepath.Join(t.TempDir(), "dup.aarch"))
+		defer outFile.Close()
+
+		_, err := Append(outFile, got, []string{newFile.FileName}, nil)
+
+		assert.ErrorIs(t, err, ErrDuplicateEntry)
+	})
+}
+
+func TestRemove(t *testing.T) {
+	reader := newTestReader(t, []testEntry{
+		{name: "one.txt", content: "AAAAAAAA"},
+		{name: "dir/"},
+		{name: "dir/two.txt", content: "BBBBBBBB"},
+		{name: "three.txt", content: "CCCCCCCC"},
+	})
+
+	t.Run("files and directories", func(t *testing.T) {
+		outFile, _ := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		defer outFile.Close()
+
+		header, err := Remove(outFile, reader, []string{"one.txt", "dir"})
+		assert.Nil(t, err)
+
+		got, err := NewReader(outFile)
+		assert.Nil(t, err)
+		assert.Equal(t, header, got.Header)
+		assert.Equal(t, 1, len(got.Header.Entries))
+
+		entry, err := FindHeaderEntryByName(io.NewSectionReader(outFile, 0, 1<<20), "three.txt")
+		assert.Nil(t, err)
+		assert.Equal(t, header.HeaderLength+1, entry.Offset)
+
+		rc, err := got.Open("three.txt")
+		assert.Nil(t, err)
+		defer rc.Close()
+
+		data, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("CCCCCCCC"), data)
+	})
+
+	t.Run("missing entry", func(t *testing.T) {
+		outFile, _ := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		defer outFile.Close()
+
+		_, err := Remove(outFile, reader, []string{"missing.txt"})
+
+		assert.ErrorIs(t, err, ErrEntryNotFoundInHeader)
+	})
+}
+
+func TestRename(t *testing.T) {
+	reader := newTestReader(t, []testEntry{
+		{name: "one.txt", content: "AAAAAAAA"},
+		{name: "dir/"},
+		{name: "dir/two.txt", content: "BBBBBBBB"},
+	})
+
+	tests := []struct {
+		name      string
+		oldName   string
+		newName   string
+		wantNames []string
+		wantErr   error
+	}{
+		{"file", "one.txt", "uno.txt", []string{"uno.txt", "dir/", "dir/two.txt"}, nil},
+		{"directory", "dir", "docs", []string{"one.txt", "docs/", "docs/two.txt"}, nil},
+		{"missing entry", "missing.txt", "other.txt", nil, ErrEntryNotFoundInHeader},
+		{"existing name", "one.txt", "dir/two.txt", nil, ErrDuplicateEntry},
+		{"file to directory name", "one.txt", "one/", nil, ErrInvalidEntryName},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outFile, _ := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+			defer outFile.Close()
+
+			_, err := Rename(outFile, reader, tt.oldName, tt.newName)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.Nil(t, err)
+
+			got, err := NewReader(outFile)
+			assert.Nil(t, err)
+
+			var names []string
+			for _, entry := range got.Header.Entries {
+				names = append(names, entry.Name)
+			}
+			assert.Equal(t, tt.wantNames, names)
+
+			for _, entry := range got.Header.Entries {
+				rc, err := got.OpenEntry(entry)
+				assert.Nil(t, err)
+				_, err = io.ReadAll(rc)
+				assert.Nil(t, err)
+				rc.Close()
+			}
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
-	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
-
-	"golang.org/x/crypto/pbkdf2"
 )
 
 const (
@@ -16,39 +16,135 @@ const (
 	nonceSize = 12
 )
 
+// The versions of the encrypted archive format.
+const (
+	// encryptedV1 is the legacy format, identified by the "AARX" magic alone, whose key
+	// is derived using PBKDF2 with 4096 iterations.
+	encryptedV1 = 1
+	// encryptedV2 writes the version right after the "AARE" magic, followed by the key
+	// derivation function and its parameters.
+	encryptedV2 = 2
+	// encryptedV3 encrypts the data in fixed-size chunks, each sealed with its own
+	// nonce, instead of as a single message.
+	encryptedV3 = 3
+	// encryptedV4 encrypts the data with a random data key, wrapped for each recipient
+	// in the key stanzas, instead of with a key derived from a password. Passwords are
+	// recipients too, whose stanza wraps the data key with the key derived from them.
+	encryptedV4 = 4
+)
+
+// currentEncryptedVersion is the version used to write new encrypted archives, and the
+// newest version this package knows how to read.
+const currentEncryptedVersion = encryptedV4
+
+// encryptedHeader is the part of an encrypted archive that goes before the encrypted data.
+type encryptedHeader struct {
+	version uint16
+	// kdf and salt derive the key from the password, up to encryptedV3.
+	kdf  KDFParams
+	salt []byte
+	// stanzas wrap the data key for each recipient, from encryptedV4 onwards.
+	stanzas []*keyStanza
+	// nonce is the AES-GCM nonce, or the chunks' nonce prefix from encryptedV3 onwards.
+	nonce     []byte
+	chunkSize uint32
+}
+
 // An EncryptedArchive represents an encrypted archive.
 type EncryptedArchive struct {
+	encryptedHeader
 	bytes []byte
-	salt  []byte
-	nonce []byte
+}
+
+// EncryptOptions configures how an archive is encrypted.
+type EncryptOptions struct {
+	// KDF is the function used to derive the key from the password, with its cost
+	// parameters. The zero value uses DefaultKDFParams.
+	KDF KDFParams
+}
+
+// KDF returns the key derivation function used to encrypt the archive, with its cost
+// parameters. It returns the zero KDFParams if the archive isn't encrypted with a
+// password.
+func (a *EncryptedArchive) KDF() KDFParams {
+	if a.version < encryptedV4 {
+		return a.kdf
+	}
+
+	for _, stanza := range a.stanzas {
+		if stanza.kind == stanzaPassword {
+			if kdf, err := readKDFParams(bytes.NewReader(stanza.body)); err == nil {
+				return kdf
+			}
+		}
+	}
+
+	return KDFParams{}
 }
 
 // Write writes the encrypted archive into the provided writer.
 // The encrypted archive is serialized as follows:
 //
 //  1. The magic field is serialized as a 4-byte sequence.
-//  2. The salt field is serialized as a 16-byte sequence.
-//  3. The nonce field is serialized as a sequence of bytes.
-//  4. The encrypted data is serialized as a sequence of bytes.
+//  2. The version field is serialized as a 2-byte sequence (omitted in v1).
+//  3. The key derivation function and its parameters are serialized as a 10-byte
+//     sequence (omitted in v1, which always uses PBKDF2 with 4096 iterations), and
+//     the salt field as a 16-byte sequence. From v4 onwards, the key stanzas are
+//     serialized instead.
+//  4. The nonce field is serialized as a 12-byte sequence, or as the 7-byte nonce prefix
+//     of the chunks from v3 onwards.
+//  5. The chunk size is serialized as a 4-byte sequence (only from v3 onwards).
+//  6. The encrypted data is serialized as a sequence of bytes: a single AES-GCM message,
+//     or the chunks from v3 onwards.
 func (a *EncryptedArchive) Write(w io.Writer) error {
-	// Write the magic (4 bytes)
-	if _, err := w.Write(encMagic); err != nil {
+	if err := a.encryptedHeader.write(w); err != nil {
+		return err
+	}
+
+	// Write the encrypted data
+	if _, err := w.Write(a.bytes); err != nil {
 		return err
 	}
 
-	// Write the salt (16 bytes)
-	if _, err := w.Write(a.salt); err != nil {
+	return nil
+}
+
+// write writes the header into the provided writer.
+func (h *encryptedHeader) write(w io.Writer) error {
+	// Write the magic (4 bytes) and version (2 bytes)
+	if err := writeEncryptedMagic(w, h.version); err != nil {
 		return err
 	}
 
+	// Write the key stanzas
+	if h.version >= encryptedV4 {
+		if err := writeKeyStanzas(w, h.stanzas); err != nil {
+			return err
+		}
+	} else {
+		// Write the key derivation parameters (10 bytes)
+		if h.version != encryptedV1 {
+			if err := h.kdf.write(w); err != nil {
+				return err
+			}
+		}
+
+		// Write the salt (16 bytes)
+		if _, err := w.Write(h.salt); err != nil {
+			return err
+		}
+	}
+
 	// Write the nonce
-	if _, err := w.Write(a.nonce); err != nil {
+	if _, err := w.Write(h.nonce); err != nil {
 		return err
 	}
 
-	// Write the encrypted data
-	if _, err := w.Write(a.bytes); err != nil {
-		return err
+	// Write the chunk size (4 bytes)
+	if h.version >= encryptedV3 {
+		if err := binary.Write(w, byteOrder, h.chunkSize); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -56,91 +152,276 @@ func (a *EncryptedArchive) Write(w io.Writer) error {
 
 // ReadEncryptedArchive reads an encrypted archive from the provided reader.
 func ReadEncryptedArchive(r io.Reader) (*EncryptedArchive, error) {
-	if err := mustReadEncryptedMagic(r); err != nil {
+	header, err := readEncryptedHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the encrypted data
+	data, err := io.ReadAll(r)
+	if err != nil {
 		return nil, err
 	}
 
-	// Read the salt (16 bytes)
-	salt := make([]byte, saltSize)
-	if _, err := io.ReadFull(r, salt); err != nil {
+	return &EncryptedArchive{
+		encryptedHeader: *header,
+		bytes:           data,
+	}, nil
+}
+
+// readEncryptedHeader reads the header of an encrypted archive from the provided reader.
+func readEncryptedHeader(r io.Reader) (*encryptedHeader, error) {
+	version, err := mustReadEncryptedMagic(r)
+	if err != nil {
 		return nil, err
 	}
 
+	header := &encryptedHeader{version: version}
+
+	// Read the key stanzas
+	if version >= encryptedV4 {
+		if header.stanzas, err = readKeyStanzas(r); err != nil {
+			return nil, err
+		}
+	} else {
+		// Read the key derivation parameters (10 bytes)
+		header.kdf = legacyKDFParams
+		if version != encryptedV1 {
+			if header.kdf, err = readKDFParams(r); err != nil {
+				return nil, err
+			}
+		}
+
+		// Read the salt (16 bytes)
+		header.salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(r, header.salt); err != nil {
+			return nil, err
+		}
+	}
+
 	// Read the nonce
-	nonce := make([]byte, nonceSize)
-	if _, err := io.ReadFull(r, nonce); err != nil {
+	header.nonce = make([]byte, nonceSize)
+	if version >= encryptedV3 {
+		header.nonce = header.nonce[:noncePrefixSize]
+	}
+	if _, err := io.ReadFull(r, header.nonce); err != nil {
 		return nil, err
 	}
 
-	// Read the encrypted data
-	data, err := io.ReadAll(r)
+	// Read the chunk size (4 bytes)
+	if version >= encryptedV3 {
+		if err := binary.Read(r, byteOrder, &header.chunkSize); err != nil {
+			return nil, err
+		}
+
+		if header.chunkSize == 0 || header.chunkSize > maxChunkSize {
+			return nil, fmt.Errorf("invalid chunk size: %d bytes", header.chunkSize)
+		}
+	}
+
+	return header, nil
+}
+
+// Encrypt encrypts the archive using AES-GCM with the provided password, deriving the
+// key that wraps the data key with DefaultKDFParams.
+func (a *Archive) Encrypt(password string) (*EncryptedArchive, error) {
+	return a.EncryptWithOptions(password, EncryptOptions{})
+}
+
+// EncryptWithOptions is like Encrypt, but it uses the options.
+func (a *Archive) EncryptWithOptions(password string, opts EncryptOptions) (*EncryptedArchive, error) {
+	var data bytes.Buffer
+
+	w, header, err := newEncryptWriter(&data, []Recipient{NewPasswordRecipient(password, opts.KDF)})
 	if err != nil {
 		return nil, err
 	}
 
+	if err := a.Write(w); err != nil {
+		return nil, err
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
 	return &EncryptedArchive{
-		bytes: data,
-		salt:  salt,
-		nonce: nonce,
+		encryptedHeader: *header,
+		bytes:           data.Bytes(),
 	}, nil
 }
 
-// Encrypt encrypts the archive using AES-GCM with the provided password.
-func (a *Archive) Encrypt(password string) (*EncryptedArchive, error) {
-	// Generate a salt for key derivation (PBKDF2)
-	salt := make([]byte, saltSize)
-	if _, err := rand.Read(salt); err != nil {
+// Decrypt decrypts the encrypted archive using AES-GCM with the provided password.
+// If the password is incorrect, the process will fail as the Archive data will be
+// corrupted.
+func (a *EncryptedArchive) Decrypt(password string) (*Archive, error) {
+	r, err := a.encryptedHeader.newDecryptReader(bytes.NewReader(a.bytes), password)
+	if err != nil {
 		return nil, err
 	}
 
-	aesGCM, err := newCipher(password, salt)
+	return ReadArchive(r)
+}
+
+// NewEncryptWriter returns a writer that encrypts the data written to it into w, using
+// the password and the options. The data is encrypted with a random data key, wrapped
+// with a key derived from the password, so that the password can be changed without
+// encrypting the data again. It must be closed to write the final chunk, but it
+// doesn't close w.
+func NewEncryptWriter(w io.Writer, password string, opts EncryptOptions) (io.WriteCloser, error) {
+	return EncryptTo(w, NewPasswordRecipient(password, opts.KDF))
+}
+
+// EncryptTo returns a writer that encrypts the data written to it into w, so that any
+// of the recipients can decrypt it. The data is encrypted with a random data key,
+// wrapped for each of the recipients in the header of the encrypted archive, which is
+// written first, and then sealed in fixed-size chunks, so that data of any size is
+// encrypted in constant memory. It must be closed to write the final chunk, but it
+// doesn't close w.
+func EncryptTo(w io.Writer, recipients ...Recipient) (io.WriteCloser, error) {
+	encWriter, header, err := newEncryptWriter(w, recipients)
 	if err != nil {
 		return nil, err
 	}
 
-	// Generate a nonce for AES-GCM (random IV)
-	// aesGCM.NonceSize() returns 12 bytes
-	nonce := make([]byte, nonceSize)
-	if _, err := rand.Read(nonce); err != nil {
+	if err := header.write(w); err != nil {
 		return nil, err
 	}
 
-	// Get the plaintext data
-	plaintext, err := a.GetBytes()
+	return encWriter, nil
+}
+
+// newEncryptWriter returns a writer that encrypts the data written to it into w for the
+// recipients, and the header to decrypt it, which isn't written.
+func newEncryptWriter(w io.Writer, recipients []Recipient) (io.WriteCloser, *encryptedHeader, error) {
+	if len(recipients) == 0 {
+		return nil, nil, fmt.Errorf("no recipients to encrypt to")
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+
+	stanzas, err := wrapDataKey(dataKey, recipients)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := &encryptedHeader{
+		version:   encryptedV4,
+		stanzas:   stanzas,
+		nonce:     make([]byte, noncePrefixSize),
+		chunkSize: defaultChunkSize,
+	}
+
+	// Generate a random prefix for the chunks' nonces
+	if _, err := rand.Read(header.nonce); err != nil {
+		return nil, nil, err
+	}
+
+	aesGCM, err := newDataCipher(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return newChunkWriter(w, aesGCM, header.nonce, nil, int(header.chunkSize)), header, nil
+}
+
+// DecryptWith reads the header of the encrypted archive from r and returns a reader that
+// decrypts the data following it, using the data key unwrapped by any of the
+// identities. It returns an ErrNoMatchingIdentity error if the archive wasn't encrypted
+// to any of them.
+func DecryptWith(r io.Reader, identities ...Identity) (io.Reader, error) {
+	header, err := readEncryptedHeader(r)
 	if err != nil {
 		return nil, err
 	}
 
-	// Encrypt the data using AES-GCM
-	ciphertext := aesGCM.Seal(nil, nonce, plaintext, nil)
+	return header.newIdentityReader(r, identities)
+}
+
+// newIdentityReader returns a reader that decrypts the data read from r, using the data
+// key unwrapped by any of the identities.
+func (h *encryptedHeader) newIdentityReader(r io.Reader, identities []Identity) (io.Reader, error) {
+	if h.version < encryptedV4 {
+		return nil, fmt.Errorf("%w: the archive is encrypted with a password", ErrNoMatchingIdentity)
+	}
 
-	return &EncryptedArchive{
-		bytes: ciphertext,
-		salt:  salt,
-		nonce: nonce,
-	}, nil
+	dataKey, err := unwrapDataKey(h.stanzas, identities)
+	if err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := newDataCipher(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newChunkReader(r, aesGCM, h.nonce, nil, int(h.chunkSize)), nil
 }
 
-// Decrypt decrypts the encrypted archive using AES-GCM with the provided password.
-// If the password is incorrect, the process will fail as the Archive data will be
-// corrupted.
-func (a *EncryptedArchive) Decrypt(password string) (*Archive, error) {
-	aesGCM, err := newCipher(password, a.salt)
+// NewDecryptReader reads the header of the encrypted archive from r and returns a reader
+// that decrypts the data following it, using the password. Archives encrypted in chunks
+// are decrypted in constant memory, while older ones, encrypted as a single message,
+// are read and decrypted in memory. It returns an ErrNoMatchingIdentity error if the
+// archive is only encrypted to public keys.
+func NewDecryptReader(r io.Reader, password string) (io.Reader, error) {
+	header, err := readEncryptedHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return header.newDecryptReader(r, password)
+}
+
+// newDecryptReader returns a reader that decrypts the data read from r, using the
+// password and the header.
+func (h *encryptedHeader) newDecryptReader(r io.Reader, password string) (io.Reader, error) {
+	if h.version >= encryptedV4 {
+		r, err := h.newIdentityReader(r, []Identity{NewPasswordIdentity(password)})
+		if errors.Is(err, ErrNoMatchingIdentity) {
+			return nil, fmt.Errorf("%w: the archive is encrypted to public keys", ErrNoMatchingIdentity)
+		}
+
+		return r, err
+	}
+
+	aesGCM, err := newCipher(password, h.salt, h.kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.version >= encryptedV3 {
+		return newChunkReader(r, aesGCM, h.nonce, nil, int(h.chunkSize)), nil
+	}
+
+	ciphertext, err := io.ReadAll(r)
 	if err != nil {
 		return nil, err
 	}
 
-	plaintext, err := aesGCM.Open(nil, a.nonce, a.bytes, nil)
+	plaintext, err := aesGCM.Open(nil, h.nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDecryptionFailed, err)
+	}
+
+	return bytes.NewReader(plaintext), nil
+}
+
+// newCipher creates a new AES-GCM cipher with the key derived from the provided
+// password and salt.
+func newCipher(password string, salt []byte, kdf KDFParams) (cipher.AEAD, error) {
+	key, err := kdf.deriveKey(password, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	return ReadArchive(bytes.NewReader(plaintext))
+	return newDataCipher(key)
 }
 
-// newCipher creates a new AES-GCM cipher with the provided password and salt.
-func newCipher(password string, salt []byte) (cipher.AEAD, error) {
-	key := pbkdf2.Key([]byte(password), salt, 4096, 32, sha256.New)
+// newDataCipher creates a new AES-GCM cipher with the provided key.
+func newDataCipher(key []byte) (cipher.AEAD, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
@@ -2,9 +2,14 @@ package archive
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"io"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 func TestEncryptDecryptArchive(t *testing.T) {
@@ -36,9 +41,101 @@ func TestWriteAndReadEncryptedArchive(t *testing.T) {
 	assert.Equal(t, encrypted, readArchive)
 }
 
+func TestEncryptWithKDFParams(t *testing.T) {
+	archive := makeTestArchive()
+
+	for _, kdf := range []KDFParams{
+		{Algorithm: KDFPBKDF2, Iterations: 1000},
+		{Algorithm: KDFArgon2id, Iterations: 1, Memory: 1024, Threads: 1},
+	} {
+		t.Run(kdf.Algorithm.String(), func(t *testing.T) {
+			encrypted, err := archive.EncryptWithOptions("password", EncryptOptions{KDF: kdf})
+			assert.Nil(t, err)
+
+			w := new(bytes.Buffer)
+			assert.Nil(t, encrypted.Write(w))
+
+			readArchive, err := ReadEncryptedArchive(bytes.NewReader(w.Bytes()))
+			assert.Nil(t, err)
+			assert.Equal(t, kdf, readArchive.KDF())
+
+			decrypted, err := readArchive.Decrypt("password")
+			assert.Nil(t, err)
+			assert.Equal(t, archive, decrypted)
+		})
+	}
+
+	t.Run("invalid parameters", func(t *testing.T) {
+		_, err := archive.EncryptWithOptions("password", EncryptOptions{
+			KDF: KDFParams{Algorithm: KDFArgon2id, Iterations: 1, Memory: 1 << 30, Threads: 1},
+		})
+
+		assert.ErrorIs(t, err, ErrInvalidKDFParams)
+	})
+}
+
+func TestEncryptAndDecryptStream(t *testing.T) {
+	var (
+		data      = bytes.Repeat([]byte("streamed archive data "), 10_000)
+		encrypted bytes.Buffer
+		opts      = EncryptOptions{KDF: KDFParams{Algorithm: KDFPBKDF2, Iterations: 1000}}
+	)
+
+	w, err := NewEncryptWriter(&encrypted, "password", opts)
+	assert.Nil(t, err)
+
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	r, err := NewDecryptReader(bytes.NewReader(encrypted.Bytes()), "password")
+	assert.Nil(t, err)
+
+	decrypted, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, data, decrypted)
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, err := NewDecryptReader(bytes.NewReader(encrypted.Bytes()), "wrong password")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+}
+
+func TestDecryptLegacyArchive(t *testing.T) {
+	var (
+		archive   = makeTestArchive()
+		salt      = bytes.Repeat([]byte{0x01}, saltSize)
+		nonce     = bytes.Repeat([]byte{0x02}, nonceSize)
+		key       = pbkdf2.Key([]byte("password"), salt, 4096, 32, sha256.New)
+		block, _  = aes.NewCipher(key)
+		aesGCM, _ = cipher.NewGCM(block)
+		data, _   = archive.GetBytes()
+		w         = new(bytes.Buffer)
+	)
+
+	w.Write(encMagic)
+	w.Write(salt)
+	w.Write(nonce)
+	w.Write(aesGCM.Seal(nil, nonce, data, nil))
+
+	encrypted, err := ReadEncryptedArchive(bytes.NewReader(w.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, legacyKDFParams, encrypted.KDF())
+
+	decrypted, err := encrypted.Decrypt("password")
+	assert.Nil(t, err)
+	assert.Equal(t, archive, decrypted)
+
+	// It's written back in the legacy format
+	rewritten := new(bytes.Buffer)
+	assert.Nil(t, encrypted.Write(rewritten))
+	assert.Equal(t, w.Bytes(), rewritten.Bytes())
+}
+
 func makeTestArchive() *Archive {
 	return &Archive{
 		Header: &Header{
+			Version:      FormatV1,
 			HeaderLength: 46,
 			Entries: []*HeaderFileEntry{
 				{
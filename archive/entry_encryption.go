@@ -0,0 +1,477 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// FlagEncryptedEntries is set in archives whose files' compressed data is encrypted
+// separately, so that a single file can be read without decrypting the whole archive.
+// The data key is wrapped in key stanzas that follow the header's preamble.
+const FlagEncryptedEntries Flags = 1 << 5
+
+// FlagEncryptedHeader is set in archives with FlagEncryptedEntries whose file entries
+// are also encrypted, hiding the names and attributes of the files.
+const FlagEncryptedHeader Flags = 1 << 6
+
+// entriesMACInfo binds the key derived from the data key to authenticate the file
+// entries to its purpose.
+var entriesMACInfo = []byte("aar entries mac")
+
+// ErrEncrypted is returned when reading the header or the files of an archive with
+// encrypted entries without the identities, or password, to decrypt them.
+var ErrEncrypted = fmt.Errorf("archive is encrypted")
+
+// entryEncryption is the encryption of the files of an archive with
+// FlagEncryptedEntries: the key stanzas wrapping the data key and, once unlocked, the
+// cipher that seals each file's data in chunks of the given size, and the key that
+// authenticates the file entries. It's shared by the header and its entries.
+type entryEncryption struct {
+	stanzas   []*keyStanza
+	chunkSize uint32
+	aead      cipher.AEAD
+	macKey    []byte
+}
+
+// newEntryEncryption creates the encryption of a new archive, with a random data key
+// wrapped for each of the recipients.
+func newEntryEncryption(recipients []Recipient) (*entryEncryption, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients to encrypt to")
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+
+	stanzas, err := wrapDataKey(dataKey, recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entryEncryption{stanzas: stanzas, chunkSize: defaultChunkSize}
+	if err := e.setDataKey(dataKey); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// setDataKey creates the cipher of the files' data and derives the key that
+// authenticates the file entries from the data key.
+func (e *entryEncryption) setDataKey(dataKey []byte) error {
+	macKey := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, dataKey, nil, entriesMACInfo), macKey); err != nil {
+		return err
+	}
+
+	aead, err := newDataCipher(dataKey)
+	if err != nil {
+		return err
+	}
+
+	e.aead, e.macKey = aead, macKey
+	return nil
+}
+
+// keyBytes returns the number of bytes required to serialize the key stanzas and the
+// chunk size.
+func (e *entryEncryption) keyBytes() uint64 {
+	total := uint64(2 + 4)
+	for _, stanza := range e.stanzas {
+		total += 1 + 2 + uint64(len(stanza.body))
+	}
+
+	return total
+}
+
+// entriesOverhead returns the number of bytes required to protect the serialized
+// entries: the nonce and authentication tag that seal them with encryptHeader, or
+// their MAC otherwise.
+func (e *entryEncryption) entriesOverhead(encryptHeader bool) uint64 {
+	if encryptHeader {
+		return nonceSize + 16
+	}
+
+	return sha256.Size
+}
+
+// totalBytes returns the number of bytes that the encryption adds to the header.
+func (e *entryEncryption) totalBytes(encryptHeader bool) uint64 {
+	return e.keyBytes() + e.entriesOverhead(encryptHeader)
+}
+
+// write writes the key stanzas and the chunk size (4 bytes) into w.
+func (e *entryEncryption) write(w io.Writer) error {
+	if err := writeKeyStanzas(w, e.stanzas); err != nil {
+		return err
+	}
+
+	return binary.Write(w, byteOrder, e.chunkSize)
+}
+
+// readEntryEncryption reads the key stanzas and chunk size written by write from r.
+func readEntryEncryption(r io.Reader) (*entryEncryption, error) {
+	stanzas, err := readKeyStanzas(r)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entryEncryption{stanzas: stanzas}
+	if err := binary.Read(r, byteOrder, &e.chunkSize); err != nil {
+		return nil, err
+	}
+
+	if e.chunkSize == 0 || e.chunkSize > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk size: %d bytes", e.chunkSize)
+	}
+
+	return e, nil
+}
+
+// unlock unwraps the data key with any of the identities, creating the cipher.
+func (e *entryEncryption) unlock(identities []Identity) error {
+	if len(identities) == 0 {
+		return fmt.Errorf("%w: no password or identities to decrypt it", ErrEncrypted)
+	}
+
+	dataKey, err := unwrapDataKey(e.stanzas, identities)
+	if err != nil {
+		return err
+	}
+
+	return e.setDataKey(dataKey)
+}
+
+// locked returns an ErrEncrypted error if the data key hasn't been unwrapped.
+func (e *entryEncryption) locked() error {
+	if e.aead == nil {
+		return fmt.Errorf("%w: its files can't be read or written without unlocking it", ErrEncrypted)
+	}
+
+	return nil
+}
+
+// sealData returns a writer that encrypts the named file's data into w: a random nonce
+// prefix, followed by the data sealed in chunks, which authenticate the name, so that
+// the data can't be swapped between files. It must be closed to write the final chunk.
+func (e *entryEncryption) sealData(w io.Writer, name string) (io.WriteCloser, error) {
+	if err := e.locked(); err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(prefix); err != nil {
+		return nil, err
+	}
+
+	return newChunkWriter(w, e.aead, prefix, []byte(name), int(e.chunkSize)), nil
+}
+
+// openData returns a reader that decrypts the named file's data written by sealData
+// from r.
+func (e *entryEncryption) openData(r io.Reader, name string) (io.Reader, error) {
+	if err := e.locked(); err != nil {
+		return nil, err
+	}
+
+	prefix := make([]byte, noncePrefixSize)
+	if _, err := io.ReadFull(r, prefix); err != nil {
+		return nil, fmt.Errorf("%w: the file's data is truncated", ErrDecryptionFailed)
+	}
+
+	return newChunkReader(r, e.aead, prefix, []byte(name), int(e.chunkSize)), nil
+}
+
+// sealEntries encrypts the serialized entries with a random nonce, authenticating the
+// preamble along with them, and writes the nonce and the sealed entries into w.
+func (e *entryEncryption) sealEntries(w io.Writer, preamble, entries []byte) error {
+	if err := e.locked(); err != nil {
+		return err
+	}
+
+	// A new nonce is used every time the header is written, as it's written twice
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	_, err := w.Write(e.aead.Seal(nonce, nonce, entries, preamble))
+	return err
+}
+
+// openEntries reads the nonce and the sealed entries, of the given length, from r and
+// decrypts them, authenticating the preamble along with them.
+func (e *entryEncryption) openEntries(r io.Reader, preamble []byte, length uint64) ([]byte, error) {
+	if err := e.locked(); err != nil {
+		return nil, err
+	}
+
+	if length < nonceSize+16 {
+		return nil, fmt.Errorf("%w: the header is truncated", ErrDecryptionFailed)
+	}
+
+	sealed, err := readEntriesBytes(r, length)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := e.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], preamble)
+	if err != nil {
+		return nil, fmt.Errorf("%w: the header", ErrDecryptionFailed)
+	}
+
+	return entries, nil
+}
+
+// entriesMAC returns the MAC of the serialized entries, authenticating the preamble
+// along with them.
+func (e *entryEncryption) entriesMAC(preamble, entries []byte) ([]byte, error) {
+	if err := e.locked(); err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, e.macKey)
+	mac.Write(preamble)
+	mac.Write(entries)
+
+	return mac.Sum(nil), nil
+}
+
+// verifyEntries returns an ErrDecryptionFailed error if the MAC of the serialized
+// entries and the preamble doesn't match want.
+func (e *entryEncryption) verifyEntries(preamble, entries, want []byte) error {
+	got, err := e.entriesMAC(preamble, entries)
+	if err != nil {
+		return err
+	}
+
+	if !hmac.Equal(got, want) {
+		return fmt.Errorf("%w: the header", ErrDecryptionFailed)
+	}
+
+	return nil
+}
+
+// readEntriesBytes reads the serialized entries, of the given length, from r.
+func readEntriesBytes(r io.Reader, length uint64) ([]byte, error) {
+	entries, err := io.ReadAll(io.LimitReader(r, int64(length)))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(entries)) != length {
+		return nil, fmt.Errorf("%w: the header is truncated", ErrDecryptionFailed)
+	}
+
+	return entries, nil
+}
+
+// setEncryption encrypts the files of the archive with the encryption and, with
+// encryptHeader, its entries, setting the flags and the header length.
+func (h *Header) setEncryption(e *entryEncryption, encryptHeader bool) {
+	h.Flags |= FlagEncryptedEntries
+	if encryptHeader {
+		h.Flags |= FlagEncryptedHeader
+	}
+
+	h.encryption = e
+	h.HeaderLength += e.totalBytes(encryptHeader)
+
+	for _, entry := range h.Entries {
+		entry.encryption = e
+	}
+}
+
+// Locked returns true if the archive's files are encrypted, and the header hasn't been
+// read with, or unlocked by, the identities to decrypt them.
+func (h *Header) Locked() bool {
+	return h.encryption != nil && h.encryption.aead == nil
+}
+
+// Unlock unwraps the data key of an archive with FlagEncryptedEntries with any of the
+// identities, so that its files can be read, and authenticates the file entries. It
+// returns an ErrNoMatchingIdentity error if the archive wasn't encrypted to any of
+// them, or an ErrDecryptionFailed error for a wrong password or tampered entries.
+// Archives without encrypted entries need no unlocking.
+func (h *Header) Unlock(identities ...Identity) error {
+	if !h.Locked() {
+		return nil
+	}
+
+	if err := h.encryption.unlock(identities); err != nil {
+		return err
+	}
+
+	var entries bytes.Buffer
+	for _, entry := range h.Entries {
+		if err := entry.Write(&entries, h.Version, h.Flags); err != nil {
+			return err
+		}
+	}
+
+	preamble, err := h.preambleBytes()
+	if err != nil {
+		return err
+	}
+
+	if err := h.encryption.verifyEntries(preamble, entries.Bytes(), h.mac); err != nil {
+		// The entries can't be trusted, so their data can't be read either
+		h.encryption.aead, h.encryption.macKey = nil, nil
+		return err
+	}
+
+	return nil
+}
+
+// hidesChecksums returns true for archives with FlagEncryptedEntries alone, whose file
+// entries can be read without the password. Their checksums aren't stored, as they'd
+// confirm guesses of the files' data; the data's encryption authenticates it instead.
+func (h *Header) hidesChecksums() bool {
+	return h.Flags&(FlagEncryptedEntries|FlagEncryptedHeader) == FlagEncryptedEntries
+}
+
+// writeEntries writes the serialized entries of an archive with FlagEncryptedEntries
+// into w: sealed along with a nonce with FlagEncryptedHeader, or preceded by their MAC
+// otherwise. Both authenticate the preamble too.
+func (h *Header) writeEntries(w io.Writer, entries []byte) error {
+	preamble, err := h.preambleBytes()
+	if err != nil {
+		return err
+	}
+
+	if h.Flags&FlagEncryptedHeader != 0 {
+		return h.encryption.sealEntries(w, preamble, entries)
+	}
+
+	mac, err := h.encryption.entriesMAC(preamble, entries)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(mac); err != nil {
+		return err
+	}
+
+	_, err = w.Write(entries)
+	return err
+}
+
+// preambleBytes returns the serialized preamble, which is authenticated along with the
+// encrypted entries.
+func (h *Header) preambleBytes() ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := writeMagic(&buf, h.Version, h.Flags); err != nil {
+		return nil, err
+	}
+
+	if err := writeUint(&buf, h.Version, h.HeaderLength); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// openEntries reads the encryption of an archive with FlagEncryptedEntries from r,
+// following the preamble, unlocking it with the identities, if any. It returns the
+// reader of the serialized entries, decrypting them for archives with
+// FlagEncryptedHeader, or authenticating them if unlocked otherwise, and their length.
+// The preamble's length is readBytes.
+func (h *Header) openEntries(r io.Reader, readBytes uint64, identities []Identity) (io.Reader, uint64, error) {
+	if h.Flags&FlagEncryptedEntries == 0 {
+		return r, remainingBytes(h.HeaderLength, readBytes), nil
+	}
+
+	encryption, err := readEntryEncryption(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	h.encryption = encryption
+	readBytes += encryption.keyBytes()
+
+	preamble, err := h.preambleBytes()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if h.Flags&FlagEncryptedHeader == 0 {
+		h.mac = make([]byte, sha256.Size)
+		if _, err := io.ReadFull(r, h.mac); err != nil {
+			return nil, 0, err
+		} else {
+			readBytes += sha256.Size
+		}
+
+		// Without identities, the entries are authenticated when unlocking the header
+		if len(identities) == 0 {
+			return r, remainingBytes(h.HeaderLength, readBytes), nil
+		}
+
+		if err := encryption.unlock(identities); err != nil {
+			return nil, 0, err
+		}
+
+		entries, err := readEntriesBytes(r, remainingBytes(h.HeaderLength, readBytes))
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if err := encryption.verifyEntries(preamble, entries, h.mac); err != nil {
+			return nil, 0, err
+		}
+
+		return bytes.NewReader(entries), uint64(len(entries)), nil
+	}
+
+	if len(identities) == 0 {
+		return nil, 0, fmt.Errorf("%w: its header can't be read without the password or identities", ErrEncrypted)
+	}
+
+	if err := encryption.unlock(identities); err != nil {
+		return nil, 0, err
+	}
+
+	entries, err := encryption.openEntries(r, preamble, remainingBytes(h.HeaderLength, readBytes))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return bytes.NewReader(entries), uint64(len(entries)), nil
+}
+
+// readEntry reads the next entry from r, in the header's format version and flags.
+// The entries of archives with FlagEncryptedEntries share the header's encryption.
+func (h *Header) readEntry(r io.Reader) (*HeaderFileEntry, error) {
+	entry, err := ReadHeaderFile(r, h.Version, h.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	entry.encryption = h.encryption
+	return entry, nil
+}
+
+// remainingBytes returns the header length minus the bytes already read, or 0 if more
+// were read.
+func remainingBytes(headerLength, readBytes uint64) uint64 {
+	if readBytes > headerLength {
+		return 0
+	}
+
+	return headerLength - readBytes
+}
@@ -0,0 +1,317 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptedEntries(t *testing.T) {
+	var (
+		password = NewPasswordIdentity("password")
+		wrong    = NewPasswordIdentity("wrong password")
+		files    = []testEntry{
+			{name: "dir/"},
+			{name: "dir/one.txt", content: strings.Repeat("AAAAAAAA", 20_000)},
+			{name: "secret-name.txt", content: "BBBBBBBB"},
+			{name: "empty.txt"},
+		}
+	)
+
+	for _, encryptHeader := range []bool{false, true} {
+		name := "entries"
+		if encryptHeader {
+			name = "entries and header"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.aarch")
+			writeEncryptedTestArchive(t, path, files, encryptHeader)
+
+			data, err := os.ReadFile(path)
+			assert.Nil(t, err)
+			assert.False(t, bytes.Contains(data, []byte("AAAAAAAA")))
+			assert.Equal(t, encryptHeader, !bytes.Contains(data, []byte("secret-name.txt")))
+
+			t.Run("without the password", func(t *testing.T) {
+				header, err := ReadHeader(bytes.NewReader(data))
+				if encryptHeader {
+					assert.ErrorIs(t, err, ErrEncrypted)
+					return
+				}
+
+				assert.Nil(t, err)
+				assert.True(t, header.Locked())
+				assert.Equal(t, len(files), len(header.Entries))
+
+				reader, err := NewReader(bytes.NewReader(data))
+				assert.Nil(t, err)
+
+				_, err = reader.Open("dir/one.txt")
+				assert.ErrorIs(t, err, ErrEncrypted)
+
+				root := filepath.Join(t.TempDir(), "out")
+				err = reader.Extract(root, reader.Header.Entries, ExtractOptions{})
+				assert.ErrorIs(t, err, ErrEncrypted)
+				assert.NoDirExists(t, root)
+
+				assert.ErrorIs(t, reader.Header.Unlock(wrong), ErrDecryptionFailed)
+				assert.Nil(t, reader.Header.Unlock(password))
+				assert.False(t, reader.Header.Locked())
+				assertArchiveFiles(t, reader, files)
+			})
+
+			t.Run("with the password", func(t *testing.T) {
+				reader, err := NewReader(bytes.NewReader(data), password)
+				assert.Nil(t, err)
+				assert.False(t, reader.Header.Locked())
+				assert.Equal(t, FlagEncryptedEntries, reader.Header.Flags&FlagEncryptedEntries)
+				assert.Equal(t, encryptHeader, reader.Header.Flags&FlagEncryptedHeader != 0)
+				assertArchiveFiles(t, reader, files)
+			})
+
+			t.Run("wrong password", func(t *testing.T) {
+				_, err := NewReader(bytes.NewReader(data), wrong)
+				assert.ErrorIs(t, err, ErrDecryptionFailed)
+			})
+
+			t.Run("find entry by name", func(t *testing.T) {
+				entry, err := FindHeaderEntryByName(bytes.NewReader(data), "secret-name.txt", password)
+				assert.Nil(t, err)
+				assert.Equal(t, "secret-name.txt", entry.Name)
+
+				file, err := ReadFileByName(bytes.NewReader(data), "secret-name.txt", password)
+				assert.Nil(t, err)
+
+				var decompressed bytes.Buffer
+				assert.Nil(t, file.WriteDecompressed(&decompressed))
+				assert.Equal(t, "BBBBBBBB", decompressed.String())
+			})
+
+			t.Run("tampered data", func(t *testing.T) {
+				reader, err := NewReader(bytes.NewReader(data), password)
+				assert.Nil(t, err)
+
+				entry, err := reader.Entry("dir/one.txt")
+				assert.Nil(t, err)
+
+				tampered := bytes.Clone(data)
+				tampered[entry.Offset+noncePrefixSize+10] ^= 0xff
+
+				reader, err = NewReader(bytes.NewReader(tampered), password)
+				assert.Nil(t, err)
+
+				// The decompressor may read the data as soon as it's opened
+				rc, err := reader.Open("dir/one.txt")
+				if err == nil {
+					_, err = io.ReadAll(rc)
+					rc.Close()
+				}
+				assert.ErrorIs(t, err, ErrDecryptionFailed)
+			})
+
+			t.Run("read into memory", func(t *testing.T) {
+				_, err := ReadArchive(bytes.NewReader(data))
+				assert.ErrorIs(t, err, ErrEncrypted)
+			})
+		})
+	}
+
+	for _, encryptHeader := range []bool{false, true} {
+		name := "tampered entries"
+		if encryptHeader {
+			name = "tampered header"
+		}
+
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "test.aarch")
+			writeEncryptedTestArchive(t, path, files, encryptHeader)
+
+			data, err := os.ReadFile(path)
+			assert.Nil(t, err)
+
+			header, err := ReadHeader(bytes.NewReader(data), password)
+			assert.Nil(t, err)
+
+			data[header.HeaderLength-1] ^= 0xff
+			_, err = ReadHeader(bytes.NewReader(data), password)
+			assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+			if !encryptHeader {
+				header, err := ReadHeader(bytes.NewReader(data))
+				assert.Nil(t, err)
+				assert.ErrorIs(t, header.Unlock(password), ErrDecryptionFailed)
+				assert.True(t, header.Locked())
+			}
+		})
+	}
+
+	t.Run("hidden checksums", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.aarch")
+		writeEncryptedTestArchive(t, path, files, false)
+
+		data, err := os.ReadFile(path)
+		assert.Nil(t, err)
+
+		checksum := NewChecksum([]byte("BBBBBBBB"))
+		assert.False(t, bytes.Contains(data, checksum[:]))
+
+		header, err := ReadHeader(bytes.NewReader(data))
+		assert.Nil(t, err)
+		for _, entry := range header.Entries {
+			assert.True(t, entry.Checksum.IsZero())
+		}
+	})
+
+	t.Run("swapped data", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "test.aarch")
+		writeEncryptedTestArchive(t, path, []testEntry{
+			{name: "one.txt", content: "AAAAAAAA"},
+			{name: "two.txt", content: "BBBBBBBB"},
+		}, false)
+
+		data, err := os.ReadFile(path)
+		assert.Nil(t, err)
+
+		reader, err := NewReader(bytes.NewReader(data), password)
+		assert.Nil(t, err)
+
+		one, two := reader.Header.Entries[0], reader.Header.Entries[1]
+		assert.Equal(t, one.Size, two.Size)
+
+		swapped := bytes.Clone(data)
+		copy(swapped[one.Offset-1:], data[two.Offset-1:two.Offset-1+two.Size])
+		copy(swapped[two.Offset-1:], data[one.Offset-1:one.Offset-1+one.Size])
+
+		reader, err = NewReader(bytes.NewReader(swapped), password)
+		assert.Nil(t, err)
+
+		rc, err := reader.Open("one.txt")
+		if err == nil {
+			_, err = io.ReadAll(rc)
+			rc.Close()
+		}
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+}
+
+func TestEditEncryptedEntries(t *testing.T) {
+	var (
+		password = NewPasswordIdentity("password")
+		files    = []testEntry{
+			{name: "one.txt", content: "AAAAAAAA"},
+			{name: "two.txt", content: "BBBBBBBB"},
+		}
+		path    = filepath.Join(t.TempDir(), "test.aarch")
+		outPath = filepath.Join(t.TempDir(), "out.aarch")
+	)
+
+	writeEncryptedTestArchive(t, path, files, true)
+
+	reader, err := OpenReader(path, password)
+	assert.Nil(t, err)
+	defer reader.Close()
+
+	outFile, err := os.Create(outPath)
+	assert.Nil(t, err)
+	defer outFile.Close()
+
+	_, err = Rename(outFile, reader.Reader, "two.txt", "renamed.txt")
+	assert.Nil(t, err)
+
+	_, err = ReadHeader(io.NewSectionReader(outFile, 0, 1<<20))
+	assert.ErrorIs(t, err, ErrEncrypted)
+
+	got, err := NewReader(outFile, password)
+	assert.Nil(t, err)
+	assert.Equal(t, FlagEncryptedEntries|FlagEncryptedHeader, got.Header.Flags&(FlagEncryptedEntries|FlagEncryptedHeader))
+	assertArchiveFiles(t, got, []testEntry{files[0], {name: "renamed.txt", content: "BBBBBBBB"}})
+}
+
+func TestChangePasswordOfEncryptedEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.aarch")
+	writeEncryptedTestArchive(t, path, []testEntry{{name: "one.txt", content: "AAAAAAAA"}}, true)
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var out bytes.Buffer
+	assert.Nil(t, ChangePassword(&out, f, "password", "new password"))
+
+	_, err = NewReader(bytes.NewReader(out.Bytes()), NewPasswordIdentity("password"))
+	assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+	reader, err := NewReader(bytes.NewReader(out.Bytes()), NewPasswordIdentity("new password"))
+	assert.Nil(t, err)
+	assertArchiveFiles(t, reader, []testEntry{{name: "one.txt", content: "AAAAAAAA"}})
+
+	t.Run("unencrypted archive", func(t *testing.T) {
+		plainPath := filepath.Join(t.TempDir(), "plain.aarch")
+		writeTestArchive(t, plainPath, []testEntry{{name: "one.txt", content: "AAAAAAAA"}})
+
+		f, err := os.Open(plainPath)
+		assert.Nil(t, err)
+		defer f.Close()
+
+		var out bytes.Buffer
+		assert.ErrorIs(t, ChangePassword(&out, f, "password", "new password"), ErrNoPassword)
+	})
+}
+
+// writeEncryptedTestArchive writes an archive with the given entries into the file at
+// path, encrypting them with the "password" password.
+func writeEncryptedTestArchive(t *testing.T, path string, files []testEntry, encryptHeader bool) {
+	outFile, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating test archive: %v", err)
+	}
+	defer outFile.Close()
+
+	entries := make([]*HeaderFileEntry, len(files))
+	for i, file := range files {
+		entries[i] = NewHeaderFileEntry(file.name, 0)
+	}
+
+	w, err := NewEncryptedWriter(outFile, entries, encryptHeader, NewPasswordRecipient("password", testKDFParams))
+	if err != nil {
+		t.Fatalf("Error creating test archive: %v", err)
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.name, "/") {
+			continue
+		}
+
+		if err := w.WriteFile(file.name, strings.NewReader(file.content)); err != nil {
+			t.Fatalf("Error writing test archive: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error writing test archive: %v", err)
+	}
+}
+
+// assertArchiveFiles asserts that the archive read by reader has the given entries.
+func assertArchiveFiles(t *testing.T, reader *Reader, files []testEntry) {
+	t.Helper()
+
+	assert.Equal(t, len(files), len(reader.Header.Entries))
+	for _, file := range files {
+		rc, err := reader.Open(file.name)
+		if !assert.Nil(t, err) {
+			continue
+		}
+
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		assert.Nil(t, err)
+		assert.Equal(t, file.content, string(data))
+	}
+}
@@ -0,0 +1,292 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// ErrInsufficientSpace is returned when the extracted files don't fit in the
+// destination's file system.
+var ErrInsufficientSpace = fmt.Errorf("not enough disk space")
+
+// ExtractOptions configures how Reader.Extract extracts the entries.
+type ExtractOptions struct {
+	// Restore selects the metadata restored in the extracted files.
+	Restore RestoreOptions
+	// AllowUnsafePaths disables the checks that prevent entries from being extracted
+	// outside of the destination directory. See SafePath.
+	AllowUnsafePaths bool
+	// Overwrite decides what happens when an extracted file already exists.
+	Overwrite OverwritePolicy
+	// ConfirmOverwrite is called to ask whether to replace the file at path with the
+	// entry, when the overwrite policy is OverwritePrompt.
+	ConfirmOverwrite func(entry *HeaderFileEntry, path string) bool
+	// BackupSuffix, if set, makes the replaced files be renamed by appending it to
+	// their name, instead of being overwritten.
+	BackupSuffix string
+	// OnExtract, if set, is called before extracting each entry.
+	OnExtract func(entry *HeaderFileEntry)
+}
+
+// Extract extracts the entries into the root directory, creating it and the entries'
+// parent directories if they don't exist. Files are decompressed straight to disk.
+//
+// Unless the options allow unsafe paths, all the entries are checked before extracting
+// any of them, returning the *UnsafePathError errors of the unsafe ones, joined.
+// Files that already exist are also checked up front, following the overwrite policy:
+// with OverwriteRefuse, the default, the ErrFileExists errors of all of them are
+// returned, joined, and nothing is extracted. Skipped files are neither extracted nor
+// reported. If the archive stores the uncompressed sizes, an ErrInsufficientSpace error is
+// returned before extracting anything if the files don't fit in the destination.
+// The directories' metadata is restored once their contents have been extracted.
+// Archives with encrypted entries whose header is locked return an ErrEncrypted error
+// without creating anything.
+func (r *Reader) Extract(root string, entries []*HeaderFileEntry, opts ExtractOptions) error {
+	if opts.Overwrite == OverwritePrompt && opts.ConfirmOverwrite == nil {
+		return fmt.Errorf("%w: prompt without a confirmation function", ErrInvalidOverwritePolicy)
+	}
+
+	if r.Header.Locked() {
+		return fmt.Errorf("%w: its files can't be extracted without unlocking it", ErrEncrypted)
+	}
+
+	paths, err := extractPaths(root, entries, opts)
+	if err != nil {
+		return err
+	}
+
+	existing, skipped, err := checkExisting(paths, entries, opts)
+	if err != nil {
+		return err
+	}
+
+	if err := r.checkSpace(root, paths, entries, existing, skipped, opts); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+
+	var dirs []int
+	for i, entry := range entries {
+		if skipped[i] {
+			continue
+		}
+
+		if opts.OnExtract != nil {
+			opts.OnExtract(entry)
+		}
+
+		var backupPath string
+		if existing[i] && opts.BackupSuffix != "" {
+			backupPath = paths[i] + opts.BackupSuffix
+		}
+
+		if err := r.extractEntry(paths[i], backupPath, entry, opts); err != nil {
+			return err
+		}
+
+		if entry.IsDir() {
+			dirs = append(dirs, i)
+		}
+	}
+
+	// Extracting into a directory changes its modification time and might need
+	// permissions that the directory didn't have when archived, so their metadata
+	// is restored last, in reverse order for children to go before parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		entry := entries[dirs[i]]
+		if err := entry.Metadata.Restore(paths[dirs[i]], opts.Restore); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// extractPaths returns the paths where the entries are extracted into the root
+// directory, checking that they're safe unless the options allow unsafe paths.
+func extractPaths(root string, entries []*HeaderFileEntry, opts ExtractOptions) ([]string, error) {
+	var (
+		paths = make([]string, len(entries))
+		errs  []error
+	)
+
+	for i, entry := range entries {
+		if opts.AllowUnsafePaths {
+			paths[i] = UnsafePath(root, entry.Name)
+			continue
+		}
+
+		path, err := SafePath(root, entry.Name)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		paths[i] = path
+	}
+
+	return paths, errors.Join(errs...)
+}
+
+// checkExisting returns which of the entries' paths already exist as files, and which
+// of them are skipped by the overwrite policy. Directories can always be extracted over
+// existing ones.
+func checkExisting(paths []string, entries []*HeaderFileEntry, opts ExtractOptions) (existing, skipped []bool, err error) {
+	var errs []error
+
+	existing = make([]bool, len(entries))
+	skipped = make([]bool, len(entries))
+
+	for i, entry := range entries {
+		info, err := os.Lstat(paths[i])
+		if errors.Is(err, os.ErrNotExist) || err == nil && entry.IsDir() && info.IsDir() {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		overwrite, err := opts.Overwrite.shouldOverwrite(paths[i], info, entry, opts)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		existing[i] = true
+		skipped[i] = !overwrite
+	}
+
+	return existing, skipped, errors.Join(errs...)
+}
+
+// checkSpace checks that the entries that aren't skipped fit in the file system of the
+// root directory, taking into account the space freed by the overwritten files. Nothing
+// is checked if the archive doesn't store the uncompressed sizes, or if the available
+// space can't be known.
+func (r *Reader) checkSpace(root string, paths []string, entries []*HeaderFileEntry, existing, skipped []bool, opts ExtractOptions) error {
+	if r.Header.Flags&FlagUncompressedSize == 0 {
+		return nil
+	}
+
+	var needed, freed uint64
+	for i, entry := range entries {
+		if skipped[i] || entry.IsDir() {
+			continue
+		}
+
+		needed += entry.UncompressedSize
+
+		if existing[i] && opts.BackupSuffix == "" {
+			if info, err := os.Stat(paths[i]); err == nil && info.Mode().IsRegular() {
+				freed += uint64(info.Size())
+			}
+		}
+	}
+
+	if needed <= freed {
+		return nil
+	}
+
+	// The root might not exist yet, so the space is checked in its closest ancestor
+	dir, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if _, err := os.Stat(dir); err == nil || filepath.Dir(dir) == dir {
+			break
+		}
+
+		dir = filepath.Dir(dir)
+	}
+
+	available, known, err := availableSpace(dir)
+	if err != nil || !known {
+		return err
+	}
+
+	if needed-freed > available {
+		return fmt.Errorf(
+			"%w: %d bytes needed, %d bytes available", ErrInsufficientSpace, needed-freed, available,
+		)
+	}
+
+	return nil
+}
+
+// extractEntry streams the decompressed entry to the path, creating its parent
+// directories, and restores its metadata. The data is written to a temporary file
+// that only replaces the path once fully read and verified, so a damaged entry never
+// replaces an existing file. With a backupPath, the existing file is renamed to it
+// first. Directory entries are created as directories, but their metadata isn't
+// restored.
+func (r *Reader) extractEntry(path, backupPath string, entry *HeaderFileEntry, opts ExtractOptions) error {
+	if entry.IsDir() {
+		if backupPath != "" {
+			if err := os.Rename(path, backupPath); err != nil {
+				return err
+			}
+		}
+
+		return os.MkdirAll(path, 0755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := r.OpenEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	tmpFile, err := createTemp(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(tmpFile, data); err != nil {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	if backupPath != "" {
+		if err := os.Rename(path, backupPath); err != nil {
+			os.Remove(tmpFile.Name())
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+
+	return entry.Metadata.Restore(path, opts.Restore)
+}
+
+// createTemp creates a new temporary file in dir, like os.CreateTemp, but with the
+// permissions of os.Create, which the extracted file keeps if they aren't restored.
+func createTemp(dir string) (*os.File, error) {
+	for {
+		name := filepath.Join(dir, fmt.Sprintf(".aar-%d.tmp", rand.Uint32()))
+
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if !errors.Is(err, os.ErrExist) {
+			return f, err
+		}
+	}
+}
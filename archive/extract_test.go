@@ -0,0 +1,248 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtract(t *testing.T) {
+	t.Run("into a missing root", func(t *testing.T) {
+		var (
+			reader = newTestReader(t, []testEntry{
+				{name: "dir/"},
+				{name: "dir/sub/one.txt", content: "AAAAAAAA"},
+				{name: "two.txt", content: "BBBBBBBB"},
+			})
+			root = filepath.Join(t.TempDir(), "out", "deep")
+		)
+
+		var extracted []string
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{
+			OnExtract: func(entry *HeaderFileEntry) {
+				extracted = append(extracted, entry.Name)
+			},
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"dir/", "dir/sub/one.txt", "two.txt"}, extracted)
+
+		data, err := os.ReadFile(filepath.Join(root, "dir", "sub", "one.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("AAAAAAAA"), data)
+
+		data, err = os.ReadFile(filepath.Join(root, "two.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("BBBBBBBB"), data)
+	})
+
+	t.Run("unsafe paths", func(t *testing.T) {
+		var (
+			reader = newTestReader(t, []testEntry{
+				{name: "one.txt", content: "AAAAAAAA"},
+				{name: "../two.txt", content: "BBBBBBBB"},
+				{name: "/three.txt", content: "CCCCCCCC"},
+			})
+			root = filepath.Join(t.TempDir(), "out")
+		)
+
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{})
+
+		assert.ErrorIs(t, err, ErrUnsafePath)
+		assert.Contains(t, err.Error(), "../two.txt")
+		assert.Contains(t, err.Error(), "/three.txt")
+		assert.NoDirExists(t, root)
+	})
+
+	t.Run("allow unsafe paths", func(t *testing.T) {
+		var (
+			reader = newTestReader(t, []testEntry{
+				{name: "../two.txt", content: "BBBBBBBB"},
+			})
+			parent = t.TempDir()
+			root   = filepath.Join(parent, "out")
+		)
+
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{AllowUnsafePaths: true})
+		assert.Nil(t, err)
+
+		data, err := os.ReadFile(filepath.Join(parent, "two.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("BBBBBBBB"), data)
+	})
+
+	t.Run("archived from an absolute path", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "src")
+		if err := os.MkdirAll(filepath.Join(src, "sub"), 0755); err != nil {
+			t.Fatalf("Error creating temp dir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(src, "sub", "one.txt"), []byte("AAAAAAAA"), 0644); err != nil {
+			t.Fatalf("Error creating temp file: %v", err)
+		}
+
+		arFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		if err != nil {
+			t.Fatalf("Error creating output file: %v", err)
+		}
+		defer arFile.Close()
+
+		_, err = CreateStream(arFile, []string{src})
+		assert.Nil(t, err)
+
+		reader, err := NewReader(arFile)
+		assert.Nil(t, err)
+
+		root := filepath.Join(t.TempDir(), "out")
+		assert.Nil(t, reader.Extract(root, reader.Header.Entries, ExtractOptions{}))
+
+		name := filepath.FromSlash(entryNameForTest(t, src))
+		data, err := os.ReadFile(filepath.Join(root, name, "sub", "one.txt"))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("AAAAAAAA"), data)
+	})
+}
+
+func TestExtractOverExistingFiles(t *testing.T) {
+	reader := newTestReader(t, []testEntry{
+		{name: "dir/"},
+		{name: "dir/one.txt", content: "AAAAAAAA"},
+		{name: "two.txt", content: "BBBBBBBB"},
+	})
+
+	// makeRoot creates a root directory with existing versions of the archived files
+	makeRoot := func(t *testing.T) string {
+		root := t.TempDir()
+		os.Mkdir(filepath.Join(root, "dir"), 0755)
+		os.WriteFile(filepath.Join(root, "dir", "one.txt"), []byte("old one"), 0644)
+		os.WriteFile(filepath.Join(root, "two.txt"), []byte("old two"), 0644)
+
+		return root
+	}
+
+	readFile := func(root string, name ...string) string {
+		data, _ := os.ReadFile(filepath.Join(append([]string{root}, name...)...))
+		return string(data)
+	}
+
+	t.Run("refuse", func(t *testing.T) {
+		root := makeRoot(t)
+
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{Overwrite: OverwriteRefuse})
+
+		assert.ErrorIs(t, err, ErrFileExists)
+		assert.Contains(t, err.Error(), filepath.Join(root, "dir", "one.txt"))
+		assert.Contains(t, err.Error(), filepath.Join(root, "two.txt"))
+		assert.Equal(t, "old one", readFile(root, "dir", "one.txt"))
+		assert.Equal(t, "old two", readFile(root, "two.txt"))
+	})
+
+	t.Run("refuse by default", func(t *testing.T) {
+		err := reader.Extract(makeRoot(t), reader.Header.Entries, ExtractOptions{})
+
+		assert.ErrorIs(t, err, ErrFileExists)
+	})
+
+	t.Run("never", func(t *testing.T) {
+		root := makeRoot(t)
+		os.Remove(filepath.Join(root, "two.txt"))
+
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{Overwrite: OverwriteNever})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "old one", readFile(root, "dir", "one.txt"))
+		assert.Equal(t, "BBBBBBBB", readFile(root, "two.txt"))
+	})
+
+	t.Run("always with backup", func(t *testing.T) {
+		root := makeRoot(t)
+
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{
+			Overwrite:    OverwriteAlways,
+			BackupSuffix: "~",
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "AAAAAAAA", readFile(root, "dir", "one.txt"))
+		assert.Equal(t, "old one", readFile(root, "dir", "one.txt~"))
+		assert.Equal(t, "BBBBBBBB", readFile(root, "two.txt"))
+		assert.Equal(t, "old two", readFile(root, "two.txt~"))
+	})
+
+	t.Run("newer", func(t *testing.T) {
+		root := makeRoot(t)
+		oldTime := time.Now().Add(-time.Hour)
+		os.Chtimes(filepath.Join(root, "two.txt"), oldTime, oldTime)
+
+		entries := copyEntries(reader.Header.Entries)
+		for _, entry := range entries {
+			entry.ModTime = time.Now().Add(-time.Minute)
+		}
+
+		err := reader.Extract(root, entries, ExtractOptions{Overwrite: OverwriteNewer})
+
+		assert.Nil(t, err)
+		assert.Equal(t, "old one", readFile(root, "dir", "one.txt"))
+		assert.Equal(t, "BBBBBBBB", readFile(root, "two.txt"))
+	})
+
+	t.Run("prompt", func(t *testing.T) {
+		root := makeRoot(t)
+
+		var prompted []string
+		err := reader.Extract(root, reader.Header.Entries, ExtractOptions{
+			Overwrite: OverwritePrompt,
+			ConfirmOverwrite: func(entry *HeaderFileEntry, path string) bool {
+				prompted = append(prompted, entry.Name)
+				return entry.Name == "two.txt"
+			},
+		})
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"dir/one.txt", "two.txt"}, prompted)
+		assert.Equal(t, "old one", readFile(root, "dir", "one.txt"))
+		assert.Equal(t, "BBBBBBBB", readFile(root, "two.txt"))
+	})
+
+	t.Run("damaged file", func(t *testing.T) {
+		root := makeRoot(t)
+
+		entries := copyEntries(reader.Header.Entries)
+		entries[2].Checksum[0] ^= 0xff
+
+		err := reader.Extract(root, entries, ExtractOptions{Overwrite: OverwriteAlways})
+
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+		assert.Equal(t, "old two", readFile(root, "two.txt"))
+
+		names, err := os.ReadDir(root)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(names))
+	})
+
+	t.Run("prompt without confirmation", func(t *testing.T) {
+		err := reader.Extract(makeRoot(t), reader.Header.Entries, ExtractOptions{Overwrite: OverwritePrompt})
+
+		assert.ErrorIs(t, err, ErrInvalidOverwritePolicy)
+	})
+}
+
+func TestExtractWithoutSpace(t *testing.T) {
+	var (
+		reader  = newTestReader(t, []testEntry{{name: "one.txt", content: "AAAAAAAA"}})
+		root    = filepath.Join(t.TempDir(), "out")
+		entries = copyEntries(reader.Header.Entries)
+	)
+
+	if _, known, _ := availableSpace(filepath.Dir(root)); !known {
+		t.Skip("Available space unknown in this platform")
+	}
+
+	entries[0].UncompressedSize = 1 << 62
+
+	err := reader.Extract(root, entries, ExtractOptions{})
+
+	assert.ErrorIs(t, err, ErrInsufficientSpace)
+	assert.NoDirExists(t, root)
+}
@@ -1,16 +1,34 @@
 package archive
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"math"
 	"os"
+	"path"
+	"strings"
 )
 
 // ArchiveFile represents a single file in the archive.
 // It includes the file's name and its compressed bytes (using xz).
 // The decompressed bytes can be obtained using the DecompressedBytes method.
+// Directories are stored as files whose name ends with a slash and have no bytes.
 type ArchiveFile struct {
 	FileName        string
 	CompressedBytes []byte
+	// Metadata holds the file's attributes, if known.
+	Metadata
+	// Checksum is the SHA-256 hash of the uncompressed data, if known.
+	Checksum Checksum
+	// UncompressedSize is the size of the uncompressed data in bytes, if known.
+	UncompressedSize uint64
+	// Method is the compression method of the compressed bytes.
+	Method CompressionMethod
+	// Level and DictSize are the compression level and xz dictionary size used to
+	// compress the bytes.
+	Level    CompressionLevel
+	DictSize uint32
 }
 
 // Write writes the compressed bytes of the file into the provided writer.
@@ -20,24 +38,46 @@ func (f *ArchiveFile) Write(w io.Writer) error {
 }
 
 // WriteDecompressed writes the decompressed bytes of the file into the provided writer.
+// If the file's checksum is known, the decompressed bytes are verified against it
+// before writing them, returning an ErrChecksumMismatch error if they don't match.
 func (f *ArchiveFile) WriteDecompressed(w io.Writer) error {
 	decompressedBytes, err := f.DecompressedBytes()
 	if err != nil {
 		return err
 	}
 
+	if err := f.Checksum.verify(f.FileName, NewChecksum(decompressedBytes)); err != nil {
+		return err
+	}
+
 	_, err = w.Write(decompressedBytes)
 	return err
 }
 
+// Verify checks that the file can be decompressed and, if its checksum is known,
+// that the decompressed bytes match it, returning an ErrChecksumMismatch otherwise.
+func (f *ArchiveFile) Verify() error {
+	return f.WriteDecompressed(io.Discard)
+}
+
+// IsDir returns true if the file represents a directory.
+func (f *ArchiveFile) IsDir() bool {
+	return isDirName(f.FileName)
+}
+
 // CompressedSize returns the size of the compressed file in bytes.
-func (f *ArchiveFile) CompressedSize() uint32 {
-	return uint32(len(f.CompressedBytes))
+func (f *ArchiveFile) CompressedSize() uint64 {
+	return uint64(len(f.CompressedBytes))
 }
 
-// DecompressedBytes returns the uncompressed bytes of the file.
+// DecompressedBytes returns the uncompressed bytes of the file, preallocating them
+// if the uncompressed size is known. Directories have no bytes.
 func (f *ArchiveFile) DecompressedBytes() ([]byte, error) {
-	return Decompress(f.CompressedBytes)
+	if f.IsDir() {
+		return []byte{}, nil
+	}
+
+	return decompress(f.CompressedBytes, f.Method, f.UncompressedSize)
 }
 
 // NewFileFromCompressedBytes creates a new ArchiveFile from a file name and its bytes.
@@ -49,7 +89,8 @@ func NewFileFromCompressedBytes(fileName string, data []byte) *ArchiveFile {
 }
 
 // NewFileFromReader creates a new ArchiveFile from a reader.
-// It reads its bytes, compresses them using xz, and returns the ArchiveFile.
+// It reads its bytes, compresses them using xz, computes their checksum, and returns
+// the ArchiveFile.
 func NewFileFromReader(reader io.Reader, fileName string) (*ArchiveFile, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
@@ -62,19 +103,64 @@ func NewFileFromReader(reader io.Reader, fileName string) (*ArchiveFile, error)
 	}
 
 	return &ArchiveFile{
-		FileName:        fileName,
-		CompressedBytes: compressedData,
+		FileName:         fileName,
+		CompressedBytes:  compressedData,
+		Checksum:         NewChecksum(data),
+		UncompressedSize: uint64(len(data)),
+		DictSize:         xzDictSize(CompressorOptions{}),
 	}, nil
 }
 
-// NewFileFromPath creates a new ArchiveFile from a file path.
+// NewFileFromPath creates a new ArchiveFile from a file path, including its metadata.
+// The file name is the relative, cleaned path, using forward slashes as separators,
+// and paths with ".." components return an *UnsafePathError. If the path is a
+// directory, the returned ArchiveFile represents the directory, not its contents.
 func NewFileFromPath(path string) (*ArchiveFile, error) {
-	reader, err := os.Open(path)
+	var file *ArchiveFile
+
+	info, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewFileFromReader(reader, path)
+	if info.IsDir() {
+		if file, err = NewDirFile(path); err != nil {
+			return nil, err
+		}
+	} else {
+		name, err := entryName(path, false)
+		if err != nil {
+			return nil, err
+		}
+
+		reader, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+
+		if file, err = NewFileFromReader(reader, name); err != nil {
+			return nil, err
+		}
+	}
+
+	file.Metadata = metadataFromFileInfo(info)
+
+	return file, nil
+}
+
+// NewDirFile creates a new ArchiveFile representing the directory at the given path.
+// Its name is named like in NewFileFromPath, ending with a slash.
+func NewDirFile(path string) (*ArchiveFile, error) {
+	name, err := entryName(path, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ArchiveFile{
+		FileName:        name,
+		CompressedBytes: []byte{},
+	}, nil
 }
 
 // ReadFiles reads the files sequentially from the provided reader using the header.
@@ -82,16 +168,51 @@ func ReadFiles(r io.Reader, header *Header) ([]*ArchiveFile, error) {
 	files := make([]*ArchiveFile, len(header.Entries))
 
 	for i, entry := range header.Entries {
-		fileData := make([]byte, entry.Size)
-		if _, err := r.Read(fileData); err != nil {
+		fileData, err := readFileData(r, entry.Size)
+		if err != nil {
 			return nil, err
 		}
 
 		files[i] = &ArchiveFile{
-			FileName:        entry.Name,
-			CompressedBytes: fileData,
+			FileName:         entry.Name,
+			CompressedBytes:  fileData,
+			Metadata:         entry.Metadata,
+			Checksum:         entry.Checksum,
+			UncompressedSize: entry.UncompressedSize,
+			Method:           entry.Method,
+			Level:            entry.Level,
+			DictSize:         entry.DictSize,
 		}
 	}
 
 	return files, nil
 }
+
+// readFileData reads a file's data of the given size from r, returning an
+// io.ErrUnexpectedEOF error if r ends before. The size comes from the archive, so the
+// buffer grows as the data is read instead of being allocated up front.
+func readFileData(r io.Reader, size uint64) ([]byte, error) {
+	if size > math.MaxInt64 {
+		return nil, fmt.Errorf("file data too large: %d bytes", size)
+	}
+
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(size)); err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// isDirName returns true if the entry name represents a directory, that is, it ends
+// with a slash.
+func isDirName(name string) bool {
+	return strings.HasSuffix(name, "/")
+}
+
+// dirName returns the cleaned name with a trailing slash, used to store directories.
+func dirName(name string) string {
+	return strings.TrimSuffix(path.Clean(name), "/") + "/"
+}
@@ -2,6 +2,8 @@ package archive
 
 import (
 	"bytes"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,6 +22,72 @@ func TestNewFileFromReader(t *testing.T) {
 	assert.Equal(t, archiveFile.FileName, fileName)
 	assert.NotEmpty(t, archiveFile.CompressedBytes)
 
+	assert.Equal(t, NewChecksum(data), archiveFile.Checksum)
+
 	uncompressed, _ := archiveFile.DecompressedBytes()
 	assert.Equal(t, data, uncompressed)
 }
+
+func TestWriteDecompressed(t *testing.T) {
+	data := []byte("AAAAAAAA")
+
+	t.Run("matching checksum", func(t *testing.T) {
+		var (
+			archiveFile, _ = NewFileFromReader(bytes.NewReader(data), "test.txt")
+			w              = new(bytes.Buffer)
+		)
+
+		assert.Nil(t, archiveFile.WriteDecompressed(w))
+		assert.Equal(t, data, w.Bytes())
+	})
+
+	t.Run("mismatching checksum", func(t *testing.T) {
+		var (
+			archiveFile, _ = NewFileFromReader(bytes.NewReader(data), "test.txt")
+			w              = new(bytes.Buffer)
+		)
+		archiveFile.Checksum = NewChecksum([]byte("BBBBBBBB"))
+
+		err := archiveFile.WriteDecompressed(w)
+
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+		assert.Empty(t, w.Bytes())
+	})
+
+	t.Run("unknown checksum", func(t *testing.T) {
+		var (
+			compressed, _ = Compress(data)
+			archiveFile   = NewFileFromCompressedBytes("test.txt", compressed)
+			w             = new(bytes.Buffer)
+		)
+
+		assert.Nil(t, archiveFile.WriteDecompressed(w))
+		assert.Equal(t, data, w.Bytes())
+	})
+
+	t.Run("corrupted data", func(t *testing.T) {
+		archiveFile, _ := NewFileFromReader(bytes.NewReader(data), "test.txt")
+		archiveFile.CompressedBytes[len(archiveFile.CompressedBytes)/2] ^= 0xFF
+
+		assert.NotNil(t, archiveFile.Verify())
+	})
+}
+
+func TestNewFileFromPath(t *testing.T) {
+	t.Run("directory", func(t *testing.T) {
+		dir := t.TempDir()
+
+		archiveFile, err := NewFileFromPath(dir)
+
+		assert.Nil(t, err)
+		assert.True(t, archiveFile.IsDir())
+		assert.Equal(t, entryNameForTest(t, dir)+"/", archiveFile.FileName)
+		assert.Empty(t, archiveFile.CompressedBytes)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := NewFileFromPath(filepath.Join(t.TempDir(), "missing.txt"))
+
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	})
+}
@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// A FormatVersion identifies the on-disk layout of an archive.
+type FormatVersion uint16
+
+const (
+	// FormatV1 is the original layout, identified by the "AAR?" magic alone. The header
+	// length, offsets and sizes are serialized using 4 bytes, so it can't represent
+	// archives bigger than 4 GiB.
+	FormatV1 FormatVersion = 1
+	// FormatV2 writes the version and the feature flags right after the "AARV" magic,
+	// and serializes the header length, offsets and sizes using 8 bytes.
+	FormatV2 FormatVersion = 2
+)
+
+// CurrentFormatVersion is the version used to write new archives, and the newest
+// version this package knows how to read.
+const CurrentFormatVersion = FormatV2
+
+// Flags is a bitfield of optional features used by an archive.
+// Only archives in FormatV2 or newer can have flags set.
+type Flags uint32
+
+// knownFlags is the set of flags this package knows how to read and write.
+const knownFlags = FlagMetadata | FlagChecksum | FlagUncompressedSize | FlagCompressionMethod |
+	FlagCompressionOptions | FlagEncryptedEntries | FlagEncryptedHeader
+
+// ErrValueOverflow is returned when an offset or size doesn't fit in the integer
+// width used by the archive's format version.
+var ErrValueOverflow = fmt.Errorf("value overflows the archive format integer width")
+
+// ErrUnsupportedVersion is returned when an archive uses a format version that this
+// package doesn't know how to read or write, typically because it was created by a
+// newer version of aar.
+var ErrUnsupportedVersion = fmt.Errorf("unsupported archive format version")
+
+// ErrUnsupportedFlags is returned when an archive uses feature flags that this
+// package doesn't know how to read or write.
+var ErrUnsupportedFlags = fmt.Errorf("unsupported archive feature flags")
+
+// checkSupported returns an ErrUnsupportedVersion or ErrUnsupportedFlags error if
+// the version and flags can't be read or written by this package.
+func checkSupported(version FormatVersion, flags Flags) error {
+	if version < FormatV1 || version > CurrentFormatVersion {
+		return fmt.Errorf("%w: %s (newest supported is %s)", ErrUnsupportedVersion, version, CurrentFormatVersion)
+	}
+
+	if unknown := flags &^ knownFlags; unknown != 0 {
+		return fmt.Errorf("%w: %#08x", ErrUnsupportedFlags, uint32(unknown))
+	}
+
+	if version == FormatV1 && flags != 0 {
+		return fmt.Errorf("%w: %s archives can't have flags", ErrUnsupportedFlags, version)
+	}
+
+	if flags&FlagEncryptedHeader != 0 && flags&FlagEncryptedEntries == 0 {
+		return fmt.Errorf("%w: an encrypted header needs encrypted entries", ErrUnsupportedFlags)
+	}
+
+	return nil
+}
+
+// uintLen returns the number of bytes used to serialize the header length, offsets
+// and sizes in the given format version.
+func (v FormatVersion) uintLen() uint64 {
+	if v == FormatV1 {
+		return 4
+	}
+
+	return 8
+}
+
+// preambleLen returns the number of bytes used by the magic, the version and flags
+// (not present in FormatV1) and the header length.
+func (v FormatVersion) preambleLen() uint64 {
+	if v == FormatV1 {
+		return uint64(magicLen) + v.uintLen()
+	}
+
+	return uint64(magicLen) + 2 + 4 + v.uintLen()
+}
+
+// writeUint writes the value using the integer width of the format version.
+// In FormatV1 archives, values that don't fit in 4 bytes return an ErrValueOverflow.
+func writeUint(w io.Writer, version FormatVersion, value uint64) error {
+	if version == FormatV1 {
+		if value > math.MaxUint32 {
+			return ErrValueOverflow
+		}
+
+		return binary.Write(w, byteOrder, uint32(value))
+	}
+
+	return binary.Write(w, byteOrder, value)
+}
+
+// readUint reads a value using the integer width of the format version.
+func readUint(r io.Reader, version FormatVersion) (uint64, error) {
+	if version == FormatV1 {
+		var value uint32
+		err := binary.Read(r, byteOrder, &value)
+		return uint64(value), err
+	}
+
+	var value uint64
+	err := binary.Read(r, byteOrder, &value)
+	return value, err
+}
+
+// String returns the version as "v" followed by its number.
+func (v FormatVersion) String() string {
+	return fmt.Sprintf("v%d", uint16(v))
+}
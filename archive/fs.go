@@ -0,0 +1,381 @@
+package archive
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// An FS is a read-only file system with the files and directories in an archive.
+// It implements fs.FS, fs.ReadDirFS, fs.StatFS and fs.ReadFileFS, so it can be used
+// with http.FileServer, template.ParseFS or fs.WalkDir, among others.
+//
+// Entry names are turned into file system paths by removing their leading and
+// trailing slashes. Entries whose name isn't a valid path (see fs.ValidPath), or
+// whose parent is a file, are left out. Directories that aren't stored in the archive
+// but contain stored files are implied.
+type FS struct {
+	r     *Reader
+	nodes map[string]*fsNode
+}
+
+// fsNode is a file or directory in an FS.
+type fsNode struct {
+	// path is the file system path of the node.
+	path string
+	// entry is the archive entry of the node, nil for implied directories.
+	entry *HeaderFileEntry
+	// size is the uncompressed size of the file or, in archives that don't store it,
+	// its compressed size. Directories have no size.
+	size     int64
+	isDir    bool
+	children []*fsNode
+}
+
+// NewFS creates an FS with the files and directories in the archive read by r.
+func NewFS(r *Reader) *FS {
+	fsys := &FS{
+		r:     r,
+		nodes: map[string]*fsNode{".": {path: ".", isDir: true}},
+	}
+
+	for _, entry := range r.Header.Entries {
+		name := strings.Trim(entry.Name, "/")
+		if name == "" || !fs.ValidPath(name) {
+			continue
+		}
+
+		if node := fsys.makeNode(name, entry.IsDir()); node != nil && node.entry == nil {
+			node.entry = entry
+			node.size = entrySize(r.Header, entry)
+		}
+	}
+
+	for _, node := range fsys.nodes {
+		slices.SortFunc(node.children, func(a, b *fsNode) int {
+			return strings.Compare(a.path, b.path)
+		})
+	}
+
+	return fsys
+}
+
+// entrySize returns the uncompressed size of the entry, or its compressed size if the
+// archive doesn't store the uncompressed one, as it can't be known without reading it.
+func entrySize(header *Header, entry *HeaderFileEntry) int64 {
+	switch {
+	case entry.IsDir():
+		return 0
+	case header.Flags&FlagUncompressedSize != 0:
+		return int64(entry.UncompressedSize)
+	default:
+		return int64(entry.Size)
+	}
+}
+
+// makeNode returns the node with the given path, creating it and its parent
+// directories if they don't exist. It returns nil if the node can't be created
+// because it, or one of its parents, exists with a different type.
+func (fsys *FS) makeNode(name string, isDir bool) *fsNode {
+	if node, ok := fsys.nodes[name]; ok {
+		if node.isDir != isDir {
+			return nil
+		}
+
+		return node
+	}
+
+	parent := fsys.makeNode(path.Dir(name), true)
+	if parent == nil {
+		return nil
+	}
+
+	node := &fsNode{path: name, isDir: isDir}
+	fsys.nodes[name] = node
+	parent.children = append(parent.children, node)
+
+	return node
+}
+
+// lookup returns the node with the given path, or a *fs.PathError if the path isn't
+// valid or doesn't exist.
+func (fsys *FS) lookup(op, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node, ok := fsys.nodes[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node, nil
+}
+
+// Open opens the named file or directory. Files are decompressed as they're read.
+func (fsys *FS) Open(name string) (fs.File, error) {
+	node, err := fsys.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.isDir {
+		return &fsDir{node: node}, nil
+	}
+
+	data, err := fsys.r.OpenEntry(node.entry)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &fsFile{fsys: fsys, node: node, data: data}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := fsys.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.isDir {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return (&fsDir{node: node}).ReadDir(-1)
+}
+
+// Stat returns the information of the named file or directory.
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	node, err := fsys.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return node.info(), nil
+}
+
+// ReadFile reads and returns the decompressed contents of the named file.
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	node, err := fsys.lookup("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.isDir {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: errors.New("is a directory")}
+	}
+
+	data, err := fsys.readAll(node)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	return data, nil
+}
+
+// readAll reads and returns the decompressed contents of the file node.
+func (fsys *FS) readAll(node *fsNode) ([]byte, error) {
+	data, err := fsys.r.OpenEntry(node.entry)
+	if err != nil {
+		return nil, err
+	}
+	defer data.Close()
+
+	return io.ReadAll(data)
+}
+
+// info returns the node's file information.
+func (node *fsNode) info() *fsFileInfo {
+	return &fsFileInfo{node: node}
+}
+
+// fsFileInfo implements fs.FileInfo and fs.DirEntry for a node.
+type fsFileInfo struct {
+	node *fsNode
+}
+
+func (fi *fsFileInfo) Name() string {
+	return path.Base(fi.node.path)
+}
+
+// Size returns the uncompressed size of the file or, in archives that don't store it,
+// its compressed size. Directories have no size.
+func (fi *fsFileInfo) Size() int64 {
+	return fi.node.size
+}
+
+// Mode returns the file's permissions stored in the archive, or read-only permissions
+// if unknown.
+func (fi *fsFileInfo) Mode() fs.FileMode {
+	var mode fs.FileMode = 0444
+	if fi.node.isDir {
+		mode = 0555
+	}
+
+	if fi.node.entry != nil && fi.node.entry.Mode != 0 {
+		mode = fi.node.entry.Mode
+	}
+
+	if fi.node.isDir {
+		mode |= fs.ModeDir
+	}
+
+	return mode
+}
+
+func (fi *fsFileInfo) ModTime() time.Time {
+	if fi.node.entry == nil {
+		return time.Time{}
+	}
+
+	return fi.node.entry.ModTime
+}
+
+func (fi *fsFileInfo) IsDir() bool {
+	return fi.node.isDir
+}
+
+// Sys returns the node's *HeaderFileEntry, or nil for implied directories.
+func (fi *fsFileInfo) Sys() any {
+	if fi.node.entry == nil {
+		return nil
+	}
+
+	return fi.node.entry
+}
+
+func (fi *fsFileInfo) Type() fs.FileMode {
+	return fi.Mode().Type()
+}
+
+func (fi *fsFileInfo) Info() (fs.FileInfo, error) {
+	return fi, nil
+}
+
+func (fi *fsFileInfo) String() string {
+	return fs.FormatFileInfo(fi)
+}
+
+// fsDir is an open directory in an FS.
+type fsDir struct {
+	node   *fsNode
+	offset int
+}
+
+func (d *fsDir) Stat() (fs.FileInfo, error) {
+	return d.node.info(), nil
+}
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.path, Err: errors.New("is a directory")}
+}
+
+func (d *fsDir) Close() error {
+	return nil
+}
+
+// ReadDir returns the next n entries of the directory, or all the remaining ones
+// if n <= 0, following the semantics of fs.ReadDirFile.
+func (d *fsDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := len(d.node.children) - d.offset
+	if n > 0 && remaining == 0 {
+		return nil, io.EOF
+	}
+
+	if n <= 0 || n > remaining {
+		n = remaining
+	}
+
+	entries := make([]fs.DirEntry, n)
+	for i := range entries {
+		entries[i] = d.node.children[d.offset+i].info()
+	}
+	d.offset += n
+
+	return entries, nil
+}
+
+// fsFile is an open file in an FS. Its data is decompressed as it's read. As the
+// compressed data can't be seeked, the first call to Seek or ReadAt decompresses the
+// whole file into memory.
+type fsFile struct {
+	fsys   *FS
+	node   *fsNode
+	data   io.ReadCloser
+	offset int64
+	buf    *bytes.Reader
+}
+
+func (f *fsFile) Stat() (fs.FileInfo, error) {
+	return f.node.info(), nil
+}
+
+func (f *fsFile) Read(p []byte) (int, error) {
+	if f.buf != nil {
+		return f.buf.Read(p)
+	}
+
+	if f.data == nil {
+		return 0, fs.ErrClosed
+	}
+
+	n, err := f.data.Read(p)
+	f.offset += int64(n)
+
+	return n, err
+}
+
+func (f *fsFile) Seek(offset int64, whence int) (int64, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+
+	return f.buf.Seek(offset, whence)
+}
+
+func (f *fsFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.load(); err != nil {
+		return 0, err
+	}
+
+	return f.buf.ReadAt(p, off)
+}
+
+func (f *fsFile) Close() error {
+	if f.data == nil {
+		return fs.ErrClosed
+	}
+
+	err := f.data.Close()
+	f.data = nil
+	f.buf = nil
+
+	return err
+}
+
+// load decompresses the whole file into memory, keeping the current read offset.
+func (f *fsFile) load() error {
+	if f.data == nil {
+		return fs.ErrClosed
+	}
+
+	if f.buf != nil {
+		return nil
+	}
+
+	data, err := f.fsys.readAll(f.node)
+	if err != nil {
+		return err
+	}
+
+	f.buf = bytes.NewReader(data)
+	_, err = f.buf.Seek(f.offset, io.SeekStart)
+
+	return err
+}
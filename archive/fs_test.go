@@ -0,0 +1,129 @@
+package archive
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFS(t *testing.T) {
+	fsys := NewFS(newTestReader(t, []testEntry{
+		{name: "assets/"},
+		{name: "assets/css/site.css", content: "body {}"},
+		{name: "assets/empty/"},
+		{name: "templates/index.html", content: "<html></html>"},
+		{name: "/root.txt", content: "root"},
+		{name: "../outside.txt", content: "outside"},
+	}))
+
+	t.Run("passes TestFS", func(t *testing.T) {
+		err := fstest.TestFS(
+			fsys,
+			"assets/css/site.css",
+			"assets/empty",
+			"templates/index.html",
+			"root.txt",
+		)
+
+		assert.Nil(t, err)
+	})
+
+	t.Run("read file", func(t *testing.T) {
+		got, err := fs.ReadFile(fsys, "templates/index.html")
+
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("<html></html>"), got)
+	})
+
+	t.Run("invalid names are left out", func(t *testing.T) {
+		_, err := fsys.Stat("../outside.txt")
+
+		assert.ErrorIs(t, err, fs.ErrInvalid)
+	})
+
+	t.Run("uncompressed size", func(t *testing.T) {
+		info, err := fsys.Stat("templates/index.html")
+
+		assert.Nil(t, err)
+		assert.Equal(t, int64(len("<html></html>")), info.Size())
+	})
+
+	t.Run("implied directories", func(t *testing.T) {
+		info, err := fsys.Stat("templates")
+
+		assert.Nil(t, err)
+		assert.True(t, info.IsDir())
+		assert.Nil(t, info.Sys())
+	})
+
+	t.Run("read dir", func(t *testing.T) {
+		entries, err := fsys.ReadDir("assets")
+		names := make([]string, len(entries))
+		for i, entry := range entries {
+			names[i] = entry.Name()
+		}
+
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"css", "empty"}, names)
+	})
+}
+
+// testEntry is a file, or a directory if its name ends with a slash, used to create
+// test archives.
+type testEntry struct {
+	name    string
+	content string
+}
+
+// newTestReader writes an archive with the given entries into a file in the test's
+// temporal directory and returns a Reader for it.
+func newTestReader(t *testing.T, files []testEntry) *Reader {
+	path := filepath.Join(t.TempDir(), "test.aarch")
+	writeTestArchive(t, path, files)
+
+	reader, err := OpenReader(path)
+	if err != nil {
+		t.Fatalf("Error opening test archive: %v", err)
+	}
+	t.Cleanup(func() { reader.Close() })
+
+	return reader.Reader
+}
+
+// writeTestArchive writes an archive with the given entries into the file at path.
+func writeTestArchive(t *testing.T, path string, files []testEntry) {
+	outFile, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Error creating test archive: %v", err)
+	}
+	defer outFile.Close()
+
+	entries := make([]*HeaderFileEntry, len(files))
+	for i, file := range files {
+		entries[i] = NewHeaderFileEntry(file.name, 0)
+	}
+
+	w, err := NewWriter(outFile, entries)
+	if err != nil {
+		t.Fatalf("Error creating test archive: %v", err)
+	}
+
+	for _, file := range files {
+		if strings.HasSuffix(file.name, "/") {
+			continue
+		}
+
+		if err := w.WriteFile(file.name, strings.NewReader(file.content)); err != nil {
+			t.Fatalf("Error writing test archive: %v", err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Error writing test archive: %v", err)
+	}
+}
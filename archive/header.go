@@ -1,6 +1,7 @@
 package archive
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -10,11 +11,51 @@ import (
 var byteOrder = binary.LittleEndian
 
 // A Header represents the metadata of the archive.
-// It includes the header's length in bytes and a list of file entries.
+// It includes the format version and flags, the header's length in bytes and a list
+// of file entries.
 type Header struct {
-	// HeaderLength is the length of the header in bytes, including the magic and header length fields.
-	HeaderLength uint32
+	// Version is the format version the header is serialized with.
+	Version FormatVersion
+	// Flags are the optional features used by the archive.
+	Flags Flags
+	// HeaderLength is the length of the header in bytes, including the magic, version, flags
+	// and header length fields.
+	HeaderLength uint64
 	Entries      []*HeaderFileEntry
+	// encryption encrypts the files of archives with FlagEncryptedEntries.
+	encryption *entryEncryption
+	// mac authenticates the entries read from archives with FlagEncryptedEntries alone.
+	mac []byte
+}
+
+// newHeader creates a header with the given entries, using the current format version
+// and the flags for all the features supported when writing. The header length is
+// computed from the entries, but their offsets are left untouched.
+func newHeader(entries []*HeaderFileEntry) *Header {
+	header := &Header{
+		Version: CurrentFormatVersion,
+		Flags:   FlagMetadata | FlagChecksum | FlagUncompressedSize | FlagCompressionMethod | FlagCompressionOptions,
+		Entries: entries,
+	}
+
+	header.HeaderLength = header.Version.preambleLen()
+	for _, entry := range entries {
+		header.HeaderLength += entry.totalBytes(header.Version, header.Flags)
+	}
+
+	return header
+}
+
+// TotalSize returns the total size of the archive in bytes.
+// It includes the header and all the files' compressed data.
+func (h *Header) TotalSize() uint64 {
+	total := h.HeaderLength
+
+	for _, entry := range h.Entries {
+		total += entry.Size
+	}
+
+	return total
 }
 
 // Write writes the header into the provided writer.
@@ -22,31 +63,69 @@ type Header struct {
 // The header is serialized as follows:
 //
 //  1. The magic field is serialized as a 4-byte sequence.
-//  2. The header length field is serialized as a 4-byte sequence.
-//  3. Each file entry is serialized as follows:
+//  2. The version field is serialized as a 2-byte sequence (omitted in v1).
+//  3. The flags field is serialized as a 4-byte sequence (omitted in v1).
+//  4. The header length field is serialized as a 4-byte (v1) or 8-byte (v2) sequence.
+//  5. Each file entry is serialized as follows:
 //     - The first 2 bytes represent the length of the file name in bytes.
 //     - The file name is serialized as a sequence of bytes.
-//     - The offset field is serialized as a 4-byte sequence.
-//     - The size field is serialized as a 4-byte sequence.
+//     - The offset field is serialized as a 4-byte (v1) or 8-byte (v2) sequence.
+//     - The size field is serialized as a 4-byte (v1) or 8-byte (v2) sequence.
+//     - With FlagMetadata, the file's metadata is serialized as a 20-byte sequence.
+//     - With FlagChecksum, the file's checksum is serialized as a 32-byte sequence.
+//  6. With FlagEncryptedEntries, the key stanzas and the chunk size of the files'
+//     encrypted data go before the file entries, which are sealed along with a 12-byte
+//     nonce with FlagEncryptedHeader, or preceded by their 32-byte MAC otherwise.
 func (h *Header) Write(w io.Writer) error {
-	bytesWritten := uint32(0)
+	bytesWritten := uint64(0)
 
-	// Write the magic (4 bytes)
-	w.Write(magic)
-	bytesWritten += 4
+	// Write the magic (4 bytes), version (2 bytes) and flags (4 bytes)
+	if err := writeMagic(w, h.Version, h.Flags); err != nil {
+		return err
+	}
 
-	// Write the header length (4 bytes)
-	if err := binary.Write(w, byteOrder, h.HeaderLength); err != nil {
+	// Write the header length (4 or 8 bytes)
+	if err := writeUint(w, h.Version, h.HeaderLength); err != nil {
 		return err
 	} else {
-		bytesWritten += 4
+		bytesWritten += h.Version.preambleLen()
+	}
+
+	// Write the key stanzas and chunk size
+	var (
+		entriesWriter = w
+		protected     *bytes.Buffer
+	)
+	if h.Flags&FlagEncryptedEntries != 0 {
+		if h.encryption == nil {
+			return fmt.Errorf("%w: missing the key stanzas", ErrEncrypted)
+		}
+
+		if err := h.encryption.write(w); err != nil {
+			return err
+		} else {
+			bytesWritten += h.encryption.keyBytes()
+		}
+
+		// The entries are buffered to seal or authenticate them
+		protected = new(bytes.Buffer)
+		entriesWriter = protected
 	}
 
 	for _, entry := range h.Entries {
-		if err := entry.Write(w); err != nil {
+		if err := entry.Write(entriesWriter, h.Version, h.Flags); err != nil {
 			return err
 		} else {
-			bytesWritten += entry.totalBytes()
+			bytesWritten += entry.totalBytes(h.Version, h.Flags)
+		}
+	}
+
+	// Write the sealed entries, or their MAC and the entries
+	if protected != nil {
+		if err := h.writeEntries(w, protected.Bytes()); err != nil {
+			return err
+		} else {
+			bytesWritten += h.encryption.entriesOverhead(h.Flags&FlagEncryptedHeader != 0)
 		}
 	}
 
@@ -63,41 +142,34 @@ func (h *Header) Write(w io.Writer) error {
 
 // ReadHeader reads the header from the provided reader and returns a Header struct.
 // It doesn't close the reader.
-func ReadHeader(r io.Reader) (*Header, error) {
-	var (
-		headerLength uint32
-		readBytes    uint32 = 0
-		fileEntries  []*HeaderFileEntry
-	)
-
-	if err := mustReadMagic(r); err != nil {
+//
+// The header of an archive with FlagEncryptedHeader is decrypted with any of the
+// identities, returning an ErrEncrypted error without them. With FlagEncryptedEntries
+// alone, the entries can be read without identities, but the header must be unlocked
+// to read the files' data; see Header.Unlock.
+func ReadHeader(r io.Reader, identities ...Identity) (*Header, error) {
+	header, readBytes, err := readPreamble(r)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += magicLen
 	}
 
-	// Read the header length (4 bytes)
-	if err := binary.Read(r, byteOrder, &headerLength); err != nil {
+	entries, entriesLength, err := header.openEntries(r, readBytes, identities)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += 4
 	}
 
-	for readBytes < headerLength {
-		entry, err := ReadHeaderFile(r)
+	for readBytes = 0; readBytes < entriesLength; {
+		entry, err := header.readEntry(entries)
 		if err != nil {
 			return nil, err
 		} else {
-			readBytes += entry.totalBytes()
+			readBytes += entry.totalBytes(header.Version, header.Flags)
 		}
 
-		fileEntries = append(fileEntries, entry)
+		header.Entries = append(header.Entries, entry)
 	}
 
-	return &Header{
-		HeaderLength: headerLength,
-		Entries:      fileEntries,
-	}, nil
+	return header, nil
 }
 
 // ErrEntryNotFoundInHeader is returned when a file entry is not found in the header.
@@ -107,31 +179,26 @@ var ErrEntryNotFoundInHeader = fmt.Errorf("entry not found in header")
 // provided name is found. It returns the file entry or a errEntryNotFoundInHeader
 // error if the file is not found. Other errors can be returned if the reader fails.
 // The reader isn't closed.
-func FindHeaderEntryByName(r io.Reader, fileName string) (*HeaderFileEntry, error) {
-	var (
-		headerLength uint32
-		readBytes    uint32 = 0
-	)
-
-	if err := mustReadMagic(r); err != nil {
+//
+// Archives with encrypted entries are unlocked with the identities, like in ReadHeader,
+// so that the entry's data can be read.
+func FindHeaderEntryByName(r io.Reader, fileName string, identities ...Identity) (*HeaderFileEntry, error) {
+	header, readBytes, err := readPreamble(r)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += magicLen
 	}
 
-	// Read the header length (4 bytes)
-	if err := binary.Read(r, byteOrder, &headerLength); err != nil {
+	entries, entriesLength, err := header.openEntries(r, readBytes, identities)
+	if err != nil {
 		return nil, err
-	} else {
-		readBytes += 4
 	}
 
-	for readBytes < headerLength {
-		entry, err := ReadHeaderFile(r)
+	for readBytes = 0; readBytes < entriesLength; {
+		entry, err := header.readEntry(entries)
 		if err != nil {
 			return nil, err
 		} else {
-			readBytes += entry.totalBytes()
+			readBytes += entry.totalBytes(header.Version, header.Flags)
 		}
 
 		if entry.Name == fileName {
@@ -141,3 +208,27 @@ func FindHeaderEntryByName(r io.Reader, fileName string) (*HeaderFileEntry, erro
 
 	return nil, ErrEntryNotFoundInHeader
 }
+
+// readPreamble reads the magic, version, flags and header length from the provided
+// reader, dispatching on the magic to the layout of the archive's format version.
+// It returns a Header without entries and the number of bytes read.
+func readPreamble(r io.Reader) (*Header, uint64, error) {
+	version, flags, err := mustReadMagic(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Read the header length (4 or 8 bytes)
+	headerLength, err := readUint(r, version)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	header := &Header{
+		Version:      version,
+		Flags:        flags,
+		HeaderLength: headerLength,
+	}
+
+	return header, version.preambleLen(), nil
+}
@@ -1,6 +1,7 @@
 package archive
 
 import (
+	"bytes"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -8,21 +9,44 @@ import (
 	"github.com/dustin/go-humanize"
 )
 
+// FlagUncompressedSize is set in archives whose entries store the size of the files'
+// uncompressed data.
+const FlagUncompressedSize Flags = 1 << 2
+
 // HeaderFileEntry represents a single file's metadata in the archive.
 type HeaderFileEntry struct {
 	// Name is a unique identifier for the file.
 	Name string
 	// Offset is the byte offset from the beginning of the archive where the file's data begins.
-	// Uses 4 bytes to store the offset.
-	Offset uint32
-	// Size is the size of the file's compressed data in bytes. Uses 4 bytes to store the size.
-	Size uint32
+	// Uses 4 bytes (v1) or 8 bytes (v2) to store the offset.
+	Offset uint64
+	// Size is the size of the file's compressed data in bytes.
+	// Uses 4 bytes (v1) or 8 bytes (v2) to store the size.
+	Size uint64
+	// Metadata holds the file's attributes. Only stored in archives with FlagMetadata.
+	Metadata
+	// Checksum is the SHA-256 hash of the uncompressed data. Only stored in archives
+	// with FlagChecksum.
+	Checksum Checksum
+	// UncompressedSize is the size of the file's uncompressed data in bytes. Only stored
+	// in archives with FlagUncompressedSize, using 8 bytes.
+	UncompressedSize uint64
+	// Method is the compression method of the file's data. Only stored in archives with
+	// FlagCompressionMethod, using 1 byte; otherwise, it's MethodXZ.
+	Method CompressionMethod
+	// Level and DictSize are the compression level and xz dictionary size used to
+	// compress the file's data. Only stored in archives with FlagCompressionOptions,
+	// using 1 and 4 bytes.
+	Level    CompressionLevel
+	DictSize uint32
+	// encryption decrypts the file's data in archives with FlagEncryptedEntries.
+	encryption *entryEncryption
 }
 
 // NewHeaderFileEntry creates a new header file entry with the given name and size,
 // setting the offset at 0, as it's impossible to know the offset until the whole
 // file is set up.
-func NewHeaderFileEntry(name string, size uint32) *HeaderFileEntry {
+func NewHeaderFileEntry(name string, size uint64) *HeaderFileEntry {
 	return &HeaderFileEntry{
 		Name:   name,
 		Offset: 0,
@@ -30,9 +54,14 @@ func NewHeaderFileEntry(name string, size uint32) *HeaderFileEntry {
 	}
 }
 
+// IsDir returns true if the entry represents a directory.
+func (f *HeaderFileEntry) IsDir() bool {
+	return isDirName(f.Name)
+}
+
 // String returns a string representation of the HeaderFileEntry.
 func (f *HeaderFileEntry) String() string {
-	size := humanize.Bytes(uint64(f.Size))
+	size := humanize.Bytes(f.Size)
 
 	return fmt.Sprintf(
 		"%s (Offset: %d bytes, Compressed size: %s [%d bytes])",
@@ -45,15 +74,41 @@ func (f *HeaderFileEntry) nameLength() uint16 {
 	return uint16(len(f.Name))
 }
 
-// totalBytes returns the total number of bytes required to serialize the HeaderFileEntry.
-// This includes the length of the file name (2 bytes), the file name itself, the
-// offset (4 bytes), and the size (4 bytes).
-func (f *HeaderFileEntry) totalBytes() uint32 {
-	return 2 + uint32(f.nameLength()) + 4 + 4
+// totalBytes returns the total number of bytes required to serialize the HeaderFileEntry
+// in the given format version and flags. This includes the length of the file name
+// (2 bytes), the file name itself, the offset, the size (4 bytes each in v1, 8 bytes
+// in v2) and, with FlagMetadata, FlagChecksum, FlagUncompressedSize,
+// FlagCompressionMethod and FlagCompressionOptions, the metadata, the checksum, the
+// uncompressed size, the compression method and the compression options.
+func (f *HeaderFileEntry) totalBytes(version FormatVersion, flags Flags) uint64 {
+	total := 2 + uint64(f.nameLength()) + 2*version.uintLen()
+
+	if flags&FlagMetadata != 0 {
+		total += metadataLen
+	}
+
+	if flags&FlagChecksum != 0 {
+		total += uint64(len(f.Checksum))
+	}
+
+	if flags&FlagUncompressedSize != 0 {
+		total += version.uintLen()
+	}
+
+	if flags&FlagCompressionMethod != 0 {
+		total += 1
+	}
+
+	if flags&FlagCompressionOptions != 0 {
+		total += 1 + 4
+	}
+
+	return total
 }
 
-// Write writes the serialized HeaderFileEntry to the provided writer.
-func (f *HeaderFileEntry) Write(w io.Writer) error {
+// Write writes the HeaderFileEntry serialized in the given format version and flags
+// to the provided writer.
+func (f *HeaderFileEntry) Write(w io.Writer, version FormatVersion, flags Flags) error {
 	// Write the length of the file name in bytes (2 bytes)
 	if err := binary.Write(w, byteOrder, f.nameLength()); err != nil {
 		return err
@@ -64,26 +119,70 @@ func (f *HeaderFileEntry) Write(w io.Writer) error {
 		return err
 	}
 
-	// Write the offset (4 bytes)
-	if err := binary.Write(w, byteOrder, f.Offset); err != nil {
+	// Write the offset (4 or 8 bytes)
+	if err := writeUint(w, version, f.Offset); err != nil {
 		return err
 	}
 
-	// Write the size (4 bytes)
-	if err := binary.Write(w, byteOrder, f.Size); err != nil {
+	// Write the size (4 or 8 bytes)
+	if err := writeUint(w, version, f.Size); err != nil {
 		return err
 	}
 
+	// Write the metadata (20 bytes)
+	if flags&FlagMetadata != 0 {
+		if err := f.Metadata.write(w); err != nil {
+			return err
+		}
+	}
+
+	// Write the checksum (32 bytes)
+	if flags&FlagChecksum != 0 {
+		if _, err := w.Write(f.Checksum[:]); err != nil {
+			return err
+		}
+	}
+
+	// Write the uncompressed size (8 bytes)
+	if flags&FlagUncompressedSize != 0 {
+		if err := writeUint(w, version, f.UncompressedSize); err != nil {
+			return err
+		}
+	}
+
+	// Write the compression method (1 byte)
+	if flags&FlagCompressionMethod != 0 {
+		if _, err := w.Write([]byte{byte(f.Method)}); err != nil {
+			return err
+		}
+	}
+
+	// Write the compression level (1 byte) and dictionary size (4 bytes)
+	if flags&FlagCompressionOptions != 0 {
+		if _, err := w.Write([]byte{byte(f.Level)}); err != nil {
+			return err
+		}
+
+		if err := binary.Write(w, byteOrder, f.DictSize); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// ReadHeaderFile reads a HeaderFileEntry from the provided reader.
-func ReadHeaderFile(r io.Reader) (*HeaderFileEntry, error) {
+// ReadHeaderFile reads a HeaderFileEntry serialized in the given format version and
+// flags from the provided reader.
+func ReadHeaderFile(r io.Reader, version FormatVersion, flags Flags) (*HeaderFileEntry, error) {
 	var (
-		nameLength uint16
-		name       []byte
-		offset     uint32
-		size       uint32
+		nameLength       uint16
+		name             []byte
+		metadata         Metadata
+		checksum         Checksum
+		uncompressedSize uint64
+		method           [1]byte
+		level            [1]byte
+		dictSize         uint32
 	)
 
 	// Read the file name length (2 bytes)
@@ -97,35 +196,101 @@ func ReadHeaderFile(r io.Reader) (*HeaderFileEntry, error) {
 		return nil, err
 	}
 
-	// Read the offset (4 bytes)
-	if err := binary.Read(r, byteOrder, &offset); err != nil {
+	// Read the offset (4 or 8 bytes)
+	offset, err := readUint(r, version)
+	if err != nil {
 		return nil, err
 	}
 
-	// Read the size (4 bytes)
-	if err := binary.Read(r, byteOrder, &size); err != nil {
+	// Read the size (4 or 8 bytes)
+	size, err := readUint(r, version)
+	if err != nil {
 		return nil, err
 	}
 
+	// Read the metadata (20 bytes)
+	if flags&FlagMetadata != 0 {
+		if metadata, err = readMetadata(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the checksum (32 bytes)
+	if flags&FlagChecksum != 0 {
+		if checksum, err = readChecksum(r); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the uncompressed size (8 bytes)
+	if flags&FlagUncompressedSize != 0 {
+		if uncompressedSize, err = readUint(r, version); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the compression method (1 byte)
+	if flags&FlagCompressionMethod != 0 {
+		if _, err := io.ReadFull(r, method[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Read the compression level (1 byte) and dictionary size (4 bytes)
+	if flags&FlagCompressionOptions != 0 {
+		if _, err := io.ReadFull(r, level[:]); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(r, byteOrder, &dictSize); err != nil {
+			return nil, err
+		}
+	}
+
 	return &HeaderFileEntry{
-		Name:   string(name),
-		Offset: offset,
-		Size:   size,
+		Name:             string(name),
+		Offset:           offset,
+		Size:             size,
+		Metadata:         metadata,
+		Checksum:         checksum,
+		UncompressedSize: uncompressedSize,
+		Method:           CompressionMethod(method[0]),
+		Level:            CompressionLevel(level[0]),
+		DictSize:         dictSize,
 	}, nil
 }
 
 // ReadFrom reads the file data from the provided ReaderSeeker, using the file's
-// offset and size
+// offset and size. In archives with FlagEncryptedEntries, the data is decrypted, which
+// needs the entry to be read from an unlocked header.
 func (f *HeaderFileEntry) ReadFrom(r ReaderSeeker) (*ArchiveFile, error) {
-	fileData := make([]byte, f.Size)
-
 	if _, err := r.Seek(int64(f.Offset-1), io.SeekStart); err != nil {
 		return nil, err
 	}
 
-	if _, err := r.Read(fileData); err != nil {
+	fileData, err := readFileData(r, f.Size)
+	if err != nil {
 		return nil, err
 	}
 
-	return NewFileFromCompressedBytes(f.Name, fileData), nil
+	if f.encryption != nil && !f.IsDir() {
+		data, err := f.encryption.openData(bytes.NewReader(fileData), f.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if fileData, err = io.ReadAll(data); err != nil {
+			return nil, err
+		}
+	}
+
+	file := NewFileFromCompressedBytes(f.Name, fileData)
+	file.Metadata = f.Metadata
+	file.Checksum = f.Checksum
+	file.UncompressedSize = f.UncompressedSize
+	file.Method = f.Method
+	file.Level = f.Level
+	file.DictSize = f.DictSize
+
+	return file, nil
 }
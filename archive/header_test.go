@@ -2,35 +2,15 @@ package archive
 
 import (
 	"bytes"
+	"io/fs"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestWriteHeader(t *testing.T) {
-	header := Header{
-		// 4 bytes for magic +
-		// 4 bytes for header length +
-		// 2 bytes for file name length +
-		// 8 bytes for file name +
-		// 8 bytes for offset and size = 26 bytes
-		HeaderLength: 26,
-		Entries: []*HeaderFileEntry{
-			{
-				Name:   "test.txt",
-				Offset: 27, // 26 bytes for the header + 1 byte
-				Size:   4,
-			},
-		},
-	}
-
-	writer := new(bytes.Buffer)
-	err := header.Write(writer)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
-
-	expected := []byte{
+var (
+	headerV1Bytes = []byte{
 		0x41, 0x41, 0x52, 0x3F, // magic
 		0x1A, 0x00, 0x00, 0x00, // header length
 		0x08, 0x00, // length of file name
@@ -38,75 +18,237 @@ func TestWriteHeader(t *testing.T) {
 		0x1B, 0x00, 0x00, 0x00, // offset
 		0x04, 0x00, 0x00, 0x00, // size
 	}
-	got := writer.Bytes()
-
-	assert.Equal(t, len(got), len(expected))
-	assert.Equal(t, got, expected)
-}
-
-func TestReadHeader(t *testing.T) {
-	data := []byte{
-		0x41, 0x41, 0x52, 0x3F, // magic
-		0x1A, 0x00, 0x00, 0x00, // header length
+	headerV2Bytes = []byte{
+		0x41, 0x41, 0x52, 0x56, // magic
+		0x02, 0x00, // version
+		0x00, 0x00, 0x00, 0x00, // flags
+		0x2C, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // header length
 		0x08, 0x00, // length of file name
 		't', 'e', 's', 't', '.', 't', 'x', 't', // file name
-		0x1B, 0x00, 0x00, 0x00, // offset
-		0x04, 0x00, 0x00, 0x00, // size
+		0x2D, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // offset
+		0x04, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, // size
 	}
-	reader := bytes.NewReader(data)
+)
 
-	header, err := ReadHeader(reader)
-	if err != nil {
-		t.Fatalf("unexpected error: %v", err)
-	}
+func TestWriteHeader(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		header := Header{
+			Version: FormatV1,
+			// 4 bytes for magic +
+			// 4 bytes for header length +
+			// 2 bytes for file name length +
+			// 8 bytes for file name +
+			// 8 bytes for offset and size = 26 bytes
+			HeaderLength: 26,
+			Entries: []*HeaderFileEntry{
+				{
+					Name:   "test.txt",
+					Offset: 27, // 26 bytes for the header + 1 byte
+					Size:   4,
+				},
+			},
+		}
 
-	assert.Equal(t, header.HeaderLength, uint32(26))
-	assert.Equal(t, len(header.Entries), 1)
-	assert.Equal(t, header.Entries[0].Name, "test.txt")
-	assert.Equal(t, header.Entries[0].Offset, uint32(27))
-	assert.Equal(t, header.Entries[0].Size, uint32(4))
-}
+		writer := new(bytes.Buffer)
+		err := header.Write(writer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
 
-func TestFindHeaderEntryByName(t *testing.T) {
-	header := Header{
-		HeaderLength: 45,
-		Entries: []*HeaderFileEntry{
-			{
-				Name:   "test.txt",
-				Offset: 27,
-				Size:   4,
+		got := writer.Bytes()
+
+		assert.Equal(t, len(got), len(headerV1Bytes))
+		assert.Equal(t, got, headerV1Bytes)
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		header := Header{
+			Version: FormatV2,
+			// 4 bytes for magic +
+			// 2 bytes for version +
+			// 4 bytes for flags +
+			// 8 bytes for header length +
+			// 2 bytes for file name length +
+			// 8 bytes for file name +
+			// 16 bytes for offset and size = 44 bytes
+			HeaderLength: 44,
+			Entries: []*HeaderFileEntry{
+				{
+					Name:   "test.txt",
+					Offset: 45, // 44 bytes for the header + 1 byte
+					Size:   1<<32 + 4,
+				},
 			},
-			{
-				Name:   "test2.txt",
-				Offset: 31,
-				Size:   5,
+		}
+
+		writer := new(bytes.Buffer)
+		err := header.Write(writer)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		got := writer.Bytes()
+
+		assert.Equal(t, len(got), len(headerV2Bytes))
+		assert.Equal(t, got, headerV2Bytes)
+	})
+
+	t.Run("v1 overflow", func(t *testing.T) {
+		header := Header{
+			Version:      FormatV1,
+			HeaderLength: 26,
+			Entries: []*HeaderFileEntry{
+				{
+					Name:   "test.txt",
+					Offset: 27,
+					Size:   1<<32 + 4,
+				},
 			},
+		}
+
+		err := header.Write(new(bytes.Buffer))
+
+		assert.ErrorIs(t, err, ErrValueOverflow)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		header := Header{Version: CurrentFormatVersion + 1}
+
+		err := header.Write(new(bytes.Buffer))
+
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+}
+
+func TestReadHeader(t *testing.T) {
+	t.Run("v1", func(t *testing.T) {
+		header, err := ReadHeader(bytes.NewReader(headerV1Bytes))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, header.Version, FormatV1)
+		assert.Equal(t, header.HeaderLength, uint64(26))
+		assert.Equal(t, len(header.Entries), 1)
+		assert.Equal(t, header.Entries[0].Name, "test.txt")
+		assert.Equal(t, header.Entries[0].Offset, uint64(27))
+		assert.Equal(t, header.Entries[0].Size, uint64(4))
+	})
+
+	t.Run("v2", func(t *testing.T) {
+		header, err := ReadHeader(bytes.NewReader(headerV2Bytes))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		assert.Equal(t, header.Version, FormatV2)
+		assert.Equal(t, header.HeaderLength, uint64(44))
+		assert.Equal(t, len(header.Entries), 1)
+		assert.Equal(t, header.Entries[0].Name, "test.txt")
+		assert.Equal(t, header.Entries[0].Offset, uint64(45))
+		assert.Equal(t, header.Entries[0].Size, uint64(1<<32+4))
+	})
+
+	t.Run("invalid magic", func(t *testing.T) {
+		_, err := ReadHeader(bytes.NewReader([]byte{0x41, 0x41, 0x52, 0x00}))
+
+		assert.ErrorIs(t, err, ErrInvalidMagic)
+	})
+
+	t.Run("unsupported version", func(t *testing.T) {
+		data := append([]byte{}, headerV2Bytes...)
+		data[4] = 0xFF
+
+		_, err := ReadHeader(bytes.NewReader(data))
+
+		assert.ErrorIs(t, err, ErrUnsupportedVersion)
+	})
+
+	t.Run("unsupported flags", func(t *testing.T) {
+		data := append([]byte{}, headerV2Bytes...)
+		data[9] = 0x80
+
+		_, err := ReadHeader(bytes.NewReader(data))
+
+		assert.ErrorIs(t, err, ErrUnsupportedFlags)
+	})
+}
+
+func TestWriteAndReadHeaderWithOptionalFields(t *testing.T) {
+	entry := &HeaderFileEntry{
+		Name:   "test.sh",
+		Offset: 65,
+		Size:   4,
+		Metadata: Metadata{
+			Mode:    0755 | fs.ModeSetgid,
+			ModTime: time.Unix(1700000000, 123456789),
+			UID:     1000,
+			GID:     100,
 		},
+		Checksum:         NewChecksum([]byte("#!/bin/sh")),
+		UncompressedSize: 9,
+		Method:           MethodGzip,
+		Level:            CompressionLevel(10),
+		DictSize:         64 << 20,
+	}
+	flags := FlagMetadata | FlagChecksum | FlagUncompressedSize | FlagCompressionMethod | FlagCompressionOptions
+	header := &Header{
+		Version:      FormatV2,
+		Flags:        flags,
+		HeaderLength: FormatV2.preambleLen() + entry.totalBytes(FormatV2, flags),
+		Entries:      []*HeaderFileEntry{entry},
 	}
 	headerBytes := new(bytes.Buffer)
-	header.Write(headerBytes)
 
-	reader := bytes.NewReader(headerBytes.Bytes())
-	entry, err := FindHeaderEntryByName(reader, "test.txt")
+	assert.Nil(t, header.Write(headerBytes))
+	assert.Equal(t, uint64(headerBytes.Len()), header.HeaderLength)
+
+	got, err := ReadHeader(bytes.NewReader(headerBytes.Bytes()))
 
 	assert.Nil(t, err)
-	assert.NotNil(t, entry)
-	assert.Equal(t, entry.Name, "test.txt")
-	assert.Equal(t, entry.Offset, uint32(27))
-	assert.Equal(t, entry.Size, uint32(4))
+	assert.Equal(t, header, got)
+}
+
+func TestFindHeaderEntryByName(t *testing.T) {
+	for _, version := range []FormatVersion{FormatV1, FormatV2} {
+		t.Run(version.String(), func(t *testing.T) {
+			header := Header{
+				Version: version,
+				Entries: []*HeaderFileEntry{
+					{
+						Name:   "test.txt",
+						Offset: 27,
+						Size:   4,
+					},
+					{
+						Name:   "test2.txt",
+						Offset: 31,
+						Size:   5,
+					},
+				},
+			}
+			header.HeaderLength = version.preambleLen() +
+				header.Entries[0].totalBytes(version, 0) +
+				header.Entries[1].totalBytes(version, 0)
+
+			headerBytes := new(bytes.Buffer)
+			header.Write(headerBytes)
+
+			reader := bytes.NewReader(headerBytes.Bytes())
+			entry, err := FindHeaderEntryByName(reader, "test2.txt")
+
+			assert.Nil(t, err)
+			assert.NotNil(t, entry)
+			assert.Equal(t, entry.Name, "test2.txt")
+			assert.Equal(t, entry.Offset, uint64(31))
+			assert.Equal(t, entry.Size, uint64(5))
+		})
+	}
 }
 
 func TestReadFrom(t *testing.T) {
 	var (
-		data = []byte{
-			0x41, 0x41, 0x52, 0x3F, // magic
-			0x1A, 0x00, 0x00, 0x00, // header length
-			0x08, 0x00, // length of file name
-			't', 'e', 's', 't', '.', 't', 'x', 't', // file name
-			0x1B, 0x00, 0x00, 0x00, // offset
-			0x04, 0x00, 0x00, 0x00, // size
-			0x41, 0x41, 0x41, 0x41, // file data
-		}
+		data   = append(append([]byte{}, headerV1Bytes...), 0x41, 0x41, 0x41, 0x41)
 		reader = bytes.NewReader(data)
 		entry  = &HeaderFileEntry{
 			Name:   "test.txt",
@@ -0,0 +1,172 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// ErrUnknownKDF is returned when reading or using an unknown key derivation function.
+var ErrUnknownKDF = fmt.Errorf("unknown key derivation function")
+
+// ErrInvalidKDFParams is returned when the cost parameters of a key derivation function
+// are out of range.
+var ErrInvalidKDFParams = fmt.Errorf("invalid key derivation parameters")
+
+// A KDFAlgorithm identifies the function used to derive the encryption key from the
+// password. It's stored in encrypted archives using 1 byte.
+type KDFAlgorithm uint8
+
+const (
+	// KDFPBKDF2 derives the key using PBKDF2 with SHA-256. Legacy encrypted archives
+	// use it with 4096 iterations.
+	KDFPBKDF2 KDFAlgorithm = 1
+	// KDFArgon2id derives the key using Argon2id, which is memory-hard. It's the default.
+	KDFArgon2id KDFAlgorithm = 2
+)
+
+var kdfAlgorithmNames = map[KDFAlgorithm]string{
+	KDFPBKDF2:   "pbkdf2",
+	KDFArgon2id: "argon2id",
+}
+
+func (a KDFAlgorithm) String() string {
+	if name, ok := kdfAlgorithmNames[a]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("KDFAlgorithm(%d)", uint8(a))
+}
+
+// ParseKDFAlgorithm returns the key derivation function with the given name: "argon2id"
+// or "pbkdf2".
+func ParseKDFAlgorithm(name string) (KDFAlgorithm, error) {
+	for algorithm, algorithmName := range kdfAlgorithmNames {
+		if algorithmName == name {
+			return algorithm, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrUnknownKDF, name)
+}
+
+// The cost parameters are capped, both when encrypting and when reading them from an
+// archive, so that a crafted archive can't exhaust the memory or hang when decrypting
+// it, before the password is even checked.
+const (
+	// maxKDFMemory caps the Argon2id memory cost, in KiB.
+	maxKDFMemory = 1 << 20
+	// maxArgon2Iterations caps the Argon2id time cost.
+	maxArgon2Iterations = 16
+	// maxKDFThreads caps the Argon2id parallelism.
+	maxKDFThreads = 16
+	// maxPBKDF2Iterations caps the PBKDF2 iterations.
+	maxPBKDF2Iterations = 10_000_000
+)
+
+// KDFParams are the key derivation function and its cost parameters, stored in the
+// encrypted archive so that they can be tuned per archive.
+type KDFParams struct {
+	Algorithm KDFAlgorithm
+	// Iterations is the number of PBKDF2 iterations, or the Argon2id time cost.
+	Iterations uint32
+	// Memory is the Argon2id memory cost in KiB. PBKDF2 doesn't use it.
+	Memory uint32
+	// Threads is the Argon2id parallelism. PBKDF2 doesn't use it.
+	Threads uint8
+}
+
+// DefaultKDFParams returns the parameters used to encrypt new archives: Argon2id with
+// 3 iterations, 64 MiB of memory and 4 threads, as recommended by RFC 9106.
+func DefaultKDFParams() KDFParams {
+	return KDFParams{
+		Algorithm:  KDFArgon2id,
+		Iterations: 3,
+		Memory:     64 << 10,
+		Threads:    4,
+	}
+}
+
+// DefaultPBKDF2Iterations is the number of PBKDF2 iterations recommended by OWASP for
+// PBKDF2 with SHA-256.
+const DefaultPBKDF2Iterations = 600_000
+
+// legacyKDFParams are the parameters of the encrypted archives written before the
+// key derivation function was stored in them.
+var legacyKDFParams = KDFParams{Algorithm: KDFPBKDF2, Iterations: 4096}
+
+// Validate returns an ErrUnknownKDF or ErrInvalidKDFParams error if the parameters
+// can't be used to derive a key.
+func (p KDFParams) Validate() error {
+	switch p.Algorithm {
+	case KDFPBKDF2:
+		if p.Iterations == 0 || p.Iterations > maxPBKDF2Iterations {
+			return fmt.Errorf(
+				"%w: PBKDF2 iterations must be between 1 and %d", ErrInvalidKDFParams, maxPBKDF2Iterations,
+			)
+		}
+	case KDFArgon2id:
+		if p.Iterations == 0 || p.Iterations > maxArgon2Iterations {
+			return fmt.Errorf(
+				"%w: Argon2id iterations must be between 1 and %d", ErrInvalidKDFParams, maxArgon2Iterations,
+			)
+		}
+		if p.Threads == 0 || p.Threads > maxKDFThreads {
+			return fmt.Errorf(
+				"%w: Argon2id threads must be between 1 and %d", ErrInvalidKDFParams, maxKDFThreads,
+			)
+		}
+		if p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory {
+			return fmt.Errorf(
+				"%w: Argon2id memory must be between %d and %d KiB",
+				ErrInvalidKDFParams, 8*uint32(p.Threads), maxKDFMemory,
+			)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownKDF, p.Algorithm)
+	}
+
+	return nil
+}
+
+// deriveKey derives the 32-byte encryption key from the password and salt.
+func (p KDFParams) deriveKey(password string, salt []byte) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+
+	if p.Algorithm == KDFPBKDF2 {
+		return pbkdf2.Key([]byte(password), salt, int(p.Iterations), 32, sha256.New), nil
+	}
+
+	return argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Threads, 32), nil
+}
+
+// String describes the algorithm and its cost parameters.
+func (p KDFParams) String() string {
+	if p.Algorithm == KDFArgon2id {
+		return fmt.Sprintf("%s (t=%d, m=%d KiB, p=%d)", p.Algorithm, p.Iterations, p.Memory, p.Threads)
+	}
+
+	return fmt.Sprintf("%s (%d iterations)", p.Algorithm, p.Iterations)
+}
+
+// write writes the parameters into w: the algorithm (1 byte), the iterations (4 bytes),
+// the memory (4 bytes) and the threads (1 byte).
+func (p KDFParams) write(w io.Writer) error {
+	return binary.Write(w, byteOrder, p)
+}
+
+// readKDFParams reads the parameters written by write from r, validating them.
+func readKDFParams(r io.Reader) (KDFParams, error) {
+	var p KDFParams
+	if err := binary.Read(r, byteOrder, &p); err != nil {
+		return KDFParams{}, err
+	}
+
+	return p, p.Validate()
+}
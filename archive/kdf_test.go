@@ -0,0 +1,63 @@
+package archive
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseKDFAlgorithm(t *testing.T) {
+	for _, algorithm := range []KDFAlgorithm{KDFPBKDF2, KDFArgon2id} {
+		got, err := ParseKDFAlgorithm(algorithm.String())
+
+		assert.Nil(t, err)
+		assert.Equal(t, algorithm, got)
+	}
+
+	_, err := ParseKDFAlgorithm("bcrypt")
+	assert.ErrorIs(t, err, ErrUnknownKDF)
+}
+
+func TestKDFParamsValidate(t *testing.T) {
+	assert.Nil(t, DefaultKDFParams().Validate())
+	assert.Nil(t, legacyKDFParams.Validate())
+
+	for name, params := range map[string]KDFParams{
+		"no iterations":     {Algorithm: KDFPBKDF2},
+		"no threads":        {Algorithm: KDFArgon2id, Iterations: 1, Memory: 1024},
+		"too little memory": {Algorithm: KDFArgon2id, Iterations: 1, Memory: 8, Threads: 4},
+		"too much memory":   {Algorithm: KDFArgon2id, Iterations: 1, Memory: maxKDFMemory + 1, Threads: 1},
+		"too many passes":   {Algorithm: KDFArgon2id, Iterations: maxArgon2Iterations + 1, Memory: 1024, Threads: 1},
+		"too many threads":  {Algorithm: KDFArgon2id, Iterations: 1, Memory: 4096, Threads: maxKDFThreads + 1},
+		"too many rounds":   {Algorithm: KDFPBKDF2, Iterations: maxPBKDF2Iterations + 1},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, params.Validate(), ErrInvalidKDFParams)
+		})
+	}
+
+	assert.ErrorIs(t, KDFParams{Algorithm: 99, Iterations: 1}.Validate(), ErrUnknownKDF)
+}
+
+func TestWriteAndReadKDFParams(t *testing.T) {
+	var (
+		params = DefaultKDFParams()
+		w      = new(bytes.Buffer)
+	)
+
+	assert.Nil(t, params.write(w))
+	assert.Equal(t, 10, w.Len())
+
+	got, err := readKDFParams(bytes.NewReader(w.Bytes()))
+	assert.Nil(t, err)
+	assert.Equal(t, params, got)
+
+	t.Run("crafted parameters", func(t *testing.T) {
+		crafted := new(bytes.Buffer)
+		assert.Nil(t, KDFParams{Algorithm: KDFPBKDF2, Iterations: 1<<32 - 1}.write(crafted))
+
+		_, err := readKDFParams(crafted)
+		assert.ErrorIs(t, err, ErrInvalidKDFParams)
+	})
+}
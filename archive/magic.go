@@ -2,58 +2,160 @@ package archive
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"io"
 )
 
-// magic is a unique identifier for the archive format.
+// magic is a unique identifier for the FormatV1 archive format.
 // It's the ASCII representation of "AAR?".
 var magic = []byte{0x41, 0x41, 0x52, 0x3F}
 
-// encMagic is a unique identifier for the encrypted archive format.
+// versionedMagic is a unique identifier for the archive format from FormatV2 onwards,
+// where the format version and flags are written right after it.
+// It's the ASCII representation of "AARV".
+var versionedMagic = []byte{0x41, 0x41, 0x52, 0x56}
+
+// encMagic is a unique identifier for the legacy encrypted archive format, whose key
+// is derived using PBKDF2 with 4096 iterations.
 // It's the ASCII representation of "AARX".
 var encMagic = []byte{0x41, 0x41, 0x52, 0x58}
 
+// versionedEncMagic is a unique identifier for the encrypted archive format from
+// encryptedV2 onwards, where the format version is written right after it.
+// It's the ASCII representation of "AARE".
+var versionedEncMagic = []byte{0x41, 0x41, 0x52, 0x45}
+
 // magicLen is the length of the magic field in bytes.
 const magicLen = uint32(4)
 
 // ErrInvalidMagic is returned when the magic field is not correct.
-var ErrInvalidMagic = fmt.Errorf("invalid magic, expected %v", magic)
+var ErrInvalidMagic = fmt.Errorf("invalid magic, expected %v or %v", magic, versionedMagic)
 
 // ErrInvalidEncMagic is returned when the magic field is not correct.
-var ErrInvalidEncMagic = fmt.Errorf("invalid magic, expected %v", encMagic)
+var ErrInvalidEncMagic = fmt.Errorf("invalid magic, expected %v or %v", encMagic, versionedEncMagic)
+
+// magicFor returns the magic that identifies the given format version.
+func magicFor(version FormatVersion) []byte {
+	if version == FormatV1 {
+		return magic
+	}
+
+	return versionedMagic
+}
 
-// mustReadMagic reads the magic field from the provided reader.
+// mustReadMagic reads the magic field from the provided reader and returns the
+// format version and flags it identifies. For versioned archives, the version and
+// flags are read from the fields following the magic; FormatV1 archives have none.
 // If the magic field is not correct, it returns an error.
-func mustReadMagic(r io.Reader) error {
-	readMagic := make([]byte, 4)
+func mustReadMagic(r io.Reader) (FormatVersion, Flags, error) {
+	var (
+		readMagic = make([]byte, 4)
+		version   FormatVersion
+		flags     Flags
+	)
 
 	// Read the magic (4 bytes)
 	if _, err := io.ReadFull(r, readMagic); err != nil {
-		return err
+		return 0, 0, err
 	}
 
 	// Check if the magic is correct
-	if !bytes.Equal(magic, readMagic) {
-		return ErrInvalidMagic
+	switch {
+	case bytes.Equal(magic, readMagic):
+		return FormatV1, 0, nil
+	case !bytes.Equal(versionedMagic, readMagic):
+		return 0, 0, ErrInvalidMagic
 	}
 
-	return nil
+	// Read the version (2 bytes)
+	if err := binary.Read(r, byteOrder, &version); err != nil {
+		return 0, 0, err
+	}
+
+	// Read the flags (4 bytes)
+	if err := binary.Read(r, byteOrder, &flags); err != nil {
+		return 0, 0, err
+	}
+
+	if err := checkSupported(version, flags); err != nil {
+		return 0, 0, err
+	}
+
+	return version, flags, nil
 }
 
-// mustReadEncryptedMagic reads the magic field from the provided reader.
-func mustReadEncryptedMagic(r io.Reader) error {
+// writeMagic writes the magic field identifying the version and, for versioned
+// archives, the version and flags fields.
+func writeMagic(w io.Writer, version FormatVersion, flags Flags) error {
+	if err := checkSupported(version, flags); err != nil {
+		return err
+	}
+
+	// Write the magic (4 bytes)
+	if _, err := w.Write(magicFor(version)); err != nil {
+		return err
+	}
+
+	if version == FormatV1 {
+		return nil
+	}
+
+	// Write the version (2 bytes)
+	if err := binary.Write(w, byteOrder, version); err != nil {
+		return err
+	}
+
+	// Write the flags (4 bytes)
+	return binary.Write(w, byteOrder, flags)
+}
+
+// mustReadEncryptedMagic reads the magic field from the provided reader and returns the
+// encrypted format version it identifies, reading it from the field following the
+// magic for versioned encrypted archives.
+// If the magic field is not correct, it returns an error.
+func mustReadEncryptedMagic(r io.Reader) (uint16, error) {
 	readMagic := make([]byte, 4)
 
 	// Read the magic (4 bytes)
 	if _, err := io.ReadFull(r, readMagic); err != nil {
-		return err
+		return 0, err
 	}
 
 	// Check if the magic is correct
-	if !bytes.Equal(encMagic, readMagic) {
-		return ErrInvalidEncMagic
+	switch {
+	case bytes.Equal(encMagic, readMagic):
+		return encryptedV1, nil
+	case !bytes.Equal(versionedEncMagic, readMagic):
+		return 0, ErrInvalidEncMagic
+	}
+
+	// Read the version (2 bytes)
+	var version uint16
+	if err := binary.Read(r, byteOrder, &version); err != nil {
+		return 0, err
+	}
+
+	if version < encryptedV2 || version > currentEncryptedVersion {
+		return 0, fmt.Errorf("%w: encrypted v%d (newest supported is v%d)", ErrUnsupportedVersion, version, currentEncryptedVersion)
+	}
+
+	return version, nil
+}
+
+// writeEncryptedMagic writes the magic field identifying the encrypted format version
+// and, for versioned encrypted archives, the version field.
+func writeEncryptedMagic(w io.Writer, version uint16) error {
+	if version == encryptedV1 {
+		_, err := w.Write(encMagic)
+		return err
+	}
+
+	// Write the magic (4 bytes)
+	if _, err := w.Write(versionedEncMagic); err != nil {
+		return err
 	}
 
-	return nil
+	// Write the version (2 bytes)
+	return binary.Write(w, byteOrder, version)
 }
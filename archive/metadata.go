@@ -0,0 +1,171 @@
+package archive
+
+import (
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+// FlagMetadata is set in archives whose entries store the files' metadata.
+const FlagMetadata Flags = 1 << 0
+
+// metadataLen is the number of bytes used to serialize the Metadata of an entry.
+const metadataLen = 4 + 8 + 4 + 4
+
+// Metadata holds the file system attributes of an archived file.
+// Its zero value means that the attributes are unknown, for example, for files
+// created from a reader.
+type Metadata struct {
+	// Mode holds the permission bits and the setuid, setgid and sticky bits.
+	Mode fs.FileMode
+	// ModTime is the modification time.
+	ModTime time.Time
+	// UID is the user ID of the owner.
+	UID uint32
+	// GID is the group ID of the owner.
+	GID uint32
+}
+
+// RestoreOptions selects which attributes are restored by Metadata.Restore.
+type RestoreOptions struct {
+	Owner       bool
+	Permissions bool
+	ModTime     bool
+}
+
+// metadataFromFileInfo returns the Metadata of the described file.
+func metadataFromFileInfo(info fs.FileInfo) Metadata {
+	uid, gid := fileOwner(info)
+
+	return Metadata{
+		Mode:    info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky),
+		ModTime: info.ModTime(),
+		UID:     uid,
+		GID:     gid,
+	}
+}
+
+// Restore applies the metadata selected in the options to the file at the given path.
+// Unknown attributes (zero mode and modification time) aren't applied. Changing the
+// owner is skipped when it fails for lack of privileges and the process isn't run by
+// root, as only root can give files away, and in platforms without Unix owners.
+func (m Metadata) Restore(path string, opts RestoreOptions) error {
+	// The owner is changed first, as chown clears the setuid and setgid bits
+	if opts.Owner {
+		if err := restoreOwner(path, m.UID, m.GID); err != nil {
+			return err
+		}
+	}
+
+	if opts.Permissions && m.Mode != 0 {
+		if err := os.Chmod(path, m.Mode); err != nil {
+			return err
+		}
+	}
+
+	if opts.ModTime && !m.ModTime.IsZero() {
+		if err := os.Chtimes(path, m.ModTime, m.ModTime); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// write writes the serialized metadata to the provided writer.
+//
+// The metadata is serialized as follows:
+//
+//  1. The mode, as Unix permission bits, is serialized as a 4-byte sequence.
+//  2. The modification time, in nanoseconds since the Unix epoch, is serialized as
+//     an 8-byte sequence. A zero value means that it's unknown.
+//  3. The user ID is serialized as a 4-byte sequence.
+//  4. The group ID is serialized as a 4-byte sequence.
+func (m Metadata) write(w io.Writer) error {
+	var modTime int64
+	if !m.ModTime.IsZero() {
+		modTime = m.ModTime.UnixNano()
+	}
+
+	if err := binary.Write(w, byteOrder, unixMode(m.Mode)); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, byteOrder, modTime); err != nil {
+		return err
+	}
+
+	if err := binary.Write(w, byteOrder, m.UID); err != nil {
+		return err
+	}
+
+	return binary.Write(w, byteOrder, m.GID)
+}
+
+// readMetadata reads the serialized metadata from the provided reader.
+func readMetadata(r io.Reader) (Metadata, error) {
+	var (
+		mode    uint32
+		modTime int64
+		m       Metadata
+	)
+
+	if err := binary.Read(r, byteOrder, &mode); err != nil {
+		return m, err
+	}
+
+	if err := binary.Read(r, byteOrder, &modTime); err != nil {
+		return m, err
+	}
+
+	if err := binary.Read(r, byteOrder, &m.UID); err != nil {
+		return m, err
+	}
+
+	if err := binary.Read(r, byteOrder, &m.GID); err != nil {
+		return m, err
+	}
+
+	m.Mode = fileMode(mode)
+	if modTime != 0 {
+		m.ModTime = time.Unix(0, modTime)
+	}
+
+	return m, nil
+}
+
+// unixMode converts the file mode into Unix permission bits.
+func unixMode(mode fs.FileMode) uint32 {
+	unix := uint32(mode.Perm())
+
+	if mode&fs.ModeSetuid != 0 {
+		unix |= 0o4000
+	}
+	if mode&fs.ModeSetgid != 0 {
+		unix |= 0o2000
+	}
+	if mode&fs.ModeSticky != 0 {
+		unix |= 0o1000
+	}
+
+	return unix
+}
+
+// fileMode converts the Unix permission bits into a file mode.
+func fileMode(unix uint32) fs.FileMode {
+	mode := fs.FileMode(unix) & fs.ModePerm
+
+	if unix&0o4000 != 0 {
+		mode |= fs.ModeSetuid
+	}
+	if unix&0o2000 != 0 {
+		mode |= fs.ModeSetgid
+	}
+	if unix&0o1000 != 0 {
+		mode |= fs.ModeSticky
+	}
+
+	return mode
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package archive
+
+import "io/fs"
+
+// fileOwner returns zero user and group IDs, as files have no Unix owner in this
+// platform.
+func fileOwner(info fs.FileInfo) (uint32, uint32) {
+	return 0, 0
+}
+
+// restoreOwner does nothing, as files have no Unix owner in this platform.
+func restoreOwner(path string, uid, gid uint32) error {
+	return nil
+}
@@ -0,0 +1,50 @@
+package archive
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnixMode(t *testing.T) {
+	var (
+		mode = 0750 | fs.ModeSetuid | fs.ModeSticky
+		unix = unixMode(mode)
+	)
+
+	assert.Equal(t, uint32(0o5750), unix)
+	assert.Equal(t, mode, fileMode(unix))
+}
+
+func TestRestoreMetadata(t *testing.T) {
+	var (
+		path     = filepath.Join(t.TempDir(), "script.sh")
+		modTime  = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+		metadata = Metadata{Mode: 0750, ModTime: modTime}
+	)
+
+	if err := os.WriteFile(path, []byte("#!/bin/sh"), 0600); err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+
+	t.Run("nothing selected", func(t *testing.T) {
+		assert.Nil(t, metadata.Restore(path, RestoreOptions{}))
+
+		info, _ := os.Stat(path)
+		assert.Equal(t, fs.FileMode(0600), info.Mode().Perm())
+		assert.False(t, info.ModTime().Equal(modTime))
+	})
+
+	t.Run("permissions and modification time", func(t *testing.T) {
+		opts := RestoreOptions{Permissions: true, ModTime: true}
+		assert.Nil(t, metadata.Restore(path, opts))
+
+		info, _ := os.Stat(path)
+		assert.Equal(t, fs.FileMode(0750), info.Mode().Perm())
+		assert.True(t, info.ModTime().Equal(modTime))
+	})
+}
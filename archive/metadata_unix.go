@@ -0,0 +1,30 @@
+//go:build unix
+
+package archive
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// fileOwner returns the user and group IDs of the described file.
+func fileOwner(info fs.FileInfo) (uint32, uint32) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return stat.Uid, stat.Gid
+	}
+
+	return 0, 0
+}
+
+// restoreOwner changes the user and group IDs of the file at the given path.
+// Permission errors are ignored when the process isn't run by root.
+func restoreOwner(path string, uid, gid uint32) error {
+	err := os.Lchown(path, int(uid), int(gid))
+	if errors.Is(err, fs.ErrPermission) && os.Geteuid() != 0 {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,75 @@
+package archive
+
+import (
+	"fmt"
+	"os"
+)
+
+// ErrFileExists is returned when extracting a file over an existing one, which the
+// overwrite policy doesn't allow.
+var ErrFileExists = fmt.Errorf("file already exists")
+
+// ErrInvalidOverwritePolicy is returned when parsing an unknown overwrite policy.
+var ErrInvalidOverwritePolicy = fmt.Errorf("invalid overwrite policy")
+
+// An OverwritePolicy decides what happens when an extracted file already exists.
+type OverwritePolicy int
+
+const (
+	// OverwriteRefuse refuses to extract the archive if any file already exists.
+	OverwriteRefuse OverwritePolicy = iota
+	// OverwriteNever skips the existing files, extracting the rest.
+	OverwriteNever
+	// OverwriteAlways replaces the existing files.
+	OverwriteAlways
+	// OverwriteNewer replaces the existing files older than the archived ones,
+	// skipping the rest.
+	OverwriteNewer
+	// OverwritePrompt asks whether to replace each existing file, skipping it if not.
+	OverwritePrompt
+)
+
+var overwritePolicyNames = map[OverwritePolicy]string{
+	OverwriteRefuse: "refuse",
+	OverwriteNever:  "never",
+	OverwriteAlways: "always",
+	OverwriteNewer:  "newer",
+	OverwritePrompt: "prompt",
+}
+
+func (p OverwritePolicy) String() string {
+	if name, ok := overwritePolicyNames[p]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("OverwritePolicy(%d)", int(p))
+}
+
+// ParseOverwritePolicy returns the overwrite policy with the given name: "refuse",
+// "never", "always", "newer" or "prompt".
+func ParseOverwritePolicy(name string) (OverwritePolicy, error) {
+	for policy, policyName := range overwritePolicyNames {
+		if policyName == name {
+			return policy, nil
+		}
+	}
+
+	return 0, fmt.Errorf("%w: %s", ErrInvalidOverwritePolicy, name)
+}
+
+// shouldOverwrite returns whether the entry is extracted over the existing file at
+// path, following the policy. It returns an ErrFileExists error with OverwriteRefuse.
+func (p OverwritePolicy) shouldOverwrite(path string, info os.FileInfo, entry *HeaderFileEntry, opts ExtractOptions) (bool, error) {
+	switch p {
+	case OverwriteNever:
+		return false, nil
+	case OverwriteAlways:
+		return true, nil
+	case OverwriteNewer:
+		return !entry.ModTime.IsZero() && entry.ModTime.After(info.ModTime()), nil
+	case OverwritePrompt:
+		return opts.ConfirmOverwrite(entry, path), nil
+	default:
+		return false, fmt.Errorf("%w: %s", ErrFileExists, path)
+	}
+}
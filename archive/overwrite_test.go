@@ -0,0 +1,19 @@
+package archive
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverwritePolicy(t *testing.T) {
+	for _, policy := range []OverwritePolicy{OverwriteRefuse, OverwriteNever, OverwriteAlways, OverwriteNewer, OverwritePrompt} {
+		got, err := ParseOverwritePolicy(policy.String())
+
+		assert.Nil(t, err)
+		assert.Equal(t, policy, got)
+	}
+
+	_, err := ParseOverwritePolicy("sometimes")
+	assert.ErrorIs(t, err, ErrInvalidOverwritePolicy)
+}
@@ -0,0 +1,236 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// passwordStanzaLen is the length of the body of a password stanza: the key derivation
+// parameters, the salt and the wrapped data key.
+const passwordStanzaLen = 10 + saltSize + dataKeySize + 16
+
+// ErrNoPassword is returned when changing the password of an archive that isn't
+// encrypted with a password.
+var ErrNoPassword = fmt.Errorf("archive not encrypted with a password")
+
+// A passwordKey wraps and unwraps the data key with a key derived from a password.
+type passwordKey struct {
+	password string
+	kdf      KDFParams
+}
+
+// NewPasswordRecipient returns a recipient that wraps the data key with a key derived
+// from the password, using the key derivation function. The zero KDFParams use
+// DefaultKDFParams.
+func NewPasswordRecipient(password string, kdf KDFParams) Recipient {
+	if kdf == (KDFParams{}) {
+		kdf = DefaultKDFParams()
+	}
+
+	return &passwordKey{password: password, kdf: kdf}
+}
+
+// NewPasswordIdentity returns an identity that unwraps the data keys wrapped with the
+// password.
+func NewPasswordIdentity(password string) Identity {
+	return &passwordKey{password: password}
+}
+
+// wrapKey encrypts the data key with a key derived from the password and a random salt.
+// The stanza holds the key derivation parameters, the salt and the wrapped key.
+func (p *passwordKey) wrapKey(dataKey []byte) (*keyStanza, error) {
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := newCipher(p.password, salt, p.kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	if err := p.kdf.write(&body); err != nil {
+		return nil, err
+	}
+	body.Write(salt)
+
+	// The salt makes the wrapping key unique, so the nonce can be fixed
+	sealed := aesGCM.Seal(body.Bytes(), make([]byte, nonceSize), dataKey, nil)
+	return &keyStanza{kind: stanzaPassword, body: sealed}, nil
+}
+
+// unwrapKey decrypts the data key wrapped with the password. A wrong password returns
+// an ErrDecryptionFailed error.
+func (p *passwordKey) unwrapKey(stanza *keyStanza) ([]byte, error) {
+	if stanza.kind != stanzaPassword || len(stanza.body) != passwordStanzaLen {
+		return nil, ErrNoMatchingIdentity
+	}
+
+	body := bytes.NewReader(stanza.body)
+	kdf, err := readKDFParams(body)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(body, salt); err != nil {
+		return nil, err
+	}
+
+	aesGCM, err := newCipher(p.password, salt, kdf)
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := aesGCM.Open(nil, make([]byte, nonceSize), stanza.body[10+saltSize:], nil)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return dataKey, nil
+}
+
+// ChangePassword copies the encrypted archive, or the archive with encrypted entries,
+// read from r into w, changing its password. Only the key stanzas are rewritten, so
+// the encrypted data is copied untouched. The data key is unwrapped with the old
+// password and wrapped again with the new one, keeping the key derivation parameters.
+// Stanzas for other passwords or recipients are kept as they are.
+//
+// It returns an ErrNoPassword error if the archive isn't encrypted with a password, and
+// an ErrDecryptionFailed error if the old password unwraps none of its password
+// stanzas. Archives encrypted before the data key was wrapped derive it from the
+// password, so they return an ErrUnsupportedVersion error, and must be decrypted and
+// encrypted again instead.
+func ChangePassword(w io.Writer, r io.ReadSeeker, oldPassword, newPassword string) error {
+	stanzas, offset, length, err := readKeyStanzasAt(r)
+	if err != nil {
+		return err
+	}
+
+	var (
+		oldIdentity = &passwordKey{password: oldPassword}
+		found       bool
+		changed     bool
+		unwrapErr   error
+	)
+
+	for i, stanza := range stanzas {
+		if stanza.kind != stanzaPassword {
+			continue
+		}
+		found = true
+
+		dataKey, err := oldIdentity.unwrapKey(stanza)
+		if err != nil {
+			if unwrapErr == nil {
+				unwrapErr = err
+			}
+			continue
+		}
+
+		kdf, err := readKDFParams(bytes.NewReader(stanza.body))
+		if err != nil {
+			return err
+		}
+
+		if stanzas[i], err = NewPasswordRecipient(newPassword, kdf).wrapKey(dataKey); err != nil {
+			return err
+		}
+		changed = true
+	}
+
+	if !found {
+		return ErrNoPassword
+	}
+	if !changed {
+		return unwrapErr
+	}
+
+	var rewritten bytes.Buffer
+	if err := writeKeyStanzas(&rewritten, stanzas); err != nil {
+		return err
+	}
+
+	// The offsets of the entries are absolute, so the stanzas must keep their length
+	if uint64(rewritten.Len()) != length {
+		return fmt.Errorf("key stanzas length mismatch: expected %d, got %d", length, rewritten.Len())
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, r, int64(offset)); err != nil {
+		return err
+	}
+	if _, err := w.Write(rewritten.Bytes()); err != nil {
+		return err
+	}
+	if _, err := r.Seek(int64(offset+length), io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, r)
+	return err
+}
+
+// readKeyStanzasAt reads the key stanzas of the encrypted archive, or of the archive
+// with encrypted entries, in f, returning them with their offset and length.
+func readKeyStanzasAt(f io.ReadSeeker) ([]*keyStanza, uint64, uint64, error) {
+	readMagic := make([]byte, magicLen)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+	if _, err := io.ReadFull(f, readMagic); err != nil {
+		return nil, 0, 0, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, 0, err
+	}
+
+	counter := &countingReader{r: f}
+	if bytes.Equal(readMagic, versionedMagic) {
+		header, _, err := readPreamble(counter)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if header.Flags&FlagEncryptedEntries == 0 {
+			return nil, 0, 0, ErrNoPassword
+		}
+	} else {
+		version, err := mustReadEncryptedMagic(counter)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+
+		if version < encryptedV4 {
+			return nil, 0, 0, fmt.Errorf(
+				"%w: encrypted v%d archives derive their key from the password, decrypt and encrypt it again instead",
+				ErrUnsupportedVersion, version,
+			)
+		}
+	}
+
+	offset := counter.n
+	stanzas, err := readKeyStanzas(counter)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	return stanzas, offset, counter.n - offset, nil
+}
+
+// countingReader is a reader that counts the number of bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKDFParams = KDFParams{Algorithm: KDFPBKDF2, Iterations: 1000}
+
+func TestPasswordRecipient(t *testing.T) {
+	var (
+		identity, _ = GenerateX25519Identity()
+		data        = bytes.Repeat([]byte("password archive data "), 10_000)
+		encrypted   bytes.Buffer
+	)
+
+	w, err := EncryptTo(&encrypted, NewPasswordRecipient("password", testKDFParams), identity.Recipient())
+	assert.Nil(t, err)
+
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	for name, decrypt := range map[string]func() (io.Reader, error){
+		"password": func() (io.Reader, error) {
+			return NewDecryptReader(bytes.NewReader(encrypted.Bytes()), "password")
+		},
+		"password identity": func() (io.Reader, error) {
+			return DecryptWith(bytes.NewReader(encrypted.Bytes()), NewPasswordIdentity("password"))
+		},
+		"x25519 identity": func() (io.Reader, error) {
+			return DecryptWith(bytes.NewReader(encrypted.Bytes()), identity)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := decrypt()
+			assert.Nil(t, err)
+
+			decrypted, err := io.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decrypted)
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		_, err := NewDecryptReader(bytes.NewReader(encrypted.Bytes()), "wrong password")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	var (
+		identity, _ = GenerateX25519Identity()
+		data        = bytes.Repeat([]byte("rotated archive data "), 10_000)
+	)
+
+	encrypt := func(t *testing.T, recipients ...Recipient) *os.File {
+		f, err := os.CreateTemp(t.TempDir(), "archive-*.aarch.enc")
+		assert.Nil(t, err)
+		t.Cleanup(func() { f.Close() })
+
+		w, err := EncryptTo(f, recipients...)
+		assert.Nil(t, err)
+
+		_, err = w.Write(data)
+		assert.Nil(t, err)
+		assert.Nil(t, w.Close())
+
+		return f
+	}
+
+	decrypt := func(data []byte, password string) ([]byte, error) {
+		r, err := NewDecryptReader(bytes.NewReader(data), password)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.ReadAll(r)
+	}
+
+	t.Run("rewrites the key stanzas only", func(t *testing.T) {
+		f := encrypt(t, NewPasswordRecipient("old", testKDFParams), identity.Recipient())
+		before, err := os.ReadFile(f.Name())
+		assert.Nil(t, err)
+
+		var out bytes.Buffer
+		assert.Nil(t, ChangePassword(&out, f, "old", "new"))
+		after := out.Bytes()
+		assert.Equal(t, len(before), len(after))
+
+		header, err := readEncryptedHeader(bytes.NewReader(after))
+		assert.Nil(t, err)
+		assert.Equal(t, before[len(before)-len(data):], after[len(after)-len(data):])
+		assert.Equal(t, stanzaX25519, header.stanzas[1].kind)
+
+		decrypted, err := decrypt(after, "new")
+		assert.Nil(t, err)
+		assert.Equal(t, data, decrypted)
+
+		_, err = decrypt(after, "old")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+
+		// The key derivation parameters are kept
+		encrypted, err := ReadEncryptedArchive(bytes.NewReader(after))
+		assert.Nil(t, err)
+		assert.Equal(t, testKDFParams, encrypted.KDF())
+
+		r, err := DecryptWith(bytes.NewReader(after), identity)
+		assert.Nil(t, err)
+		decrypted, err = io.ReadAll(r)
+		assert.Nil(t, err)
+		assert.Equal(t, data, decrypted)
+
+		// The original archive is left untouched
+		unchanged, err := os.ReadFile(f.Name())
+		assert.Nil(t, err)
+		assert.Equal(t, before, unchanged)
+	})
+
+	t.Run("several passwords", func(t *testing.T) {
+		f := encrypt(t, NewPasswordRecipient("other", testKDFParams), NewPasswordRecipient("old", testKDFParams))
+		before, err := os.ReadFile(f.Name())
+		assert.Nil(t, err)
+
+		decrypted, err := decrypt(before, "old")
+		assert.Nil(t, err)
+		assert.Equal(t, data, decrypted)
+
+		var out bytes.Buffer
+		assert.Nil(t, ChangePassword(&out, f, "old", "new"))
+
+		for _, password := range []string{"new", "other"} {
+			decrypted, err := decrypt(out.Bytes(), password)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decrypted)
+		}
+
+		_, err = decrypt(out.Bytes(), "old")
+		assert.ErrorIs(t, err, ErrDecryptionFailed)
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		f := encrypt(t, NewPasswordRecipient("old", testKDFParams))
+
+		var out bytes.Buffer
+		assert.ErrorIs(t, ChangePassword(&out, f, "wrong", "new"), ErrDecryptionFailed)
+	})
+
+	t.Run("no password", func(t *testing.T) {
+		f := encrypt(t, identity.Recipient())
+
+		var out bytes.Buffer
+		assert.ErrorIs(t, ChangePassword(&out, f, "old", "new"), ErrNoPassword)
+	})
+
+	t.Run("legacy archive", func(t *testing.T) {
+		var w bytes.Buffer
+		header := &encryptedHeader{
+			version:   encryptedV3,
+			kdf:       testKDFParams,
+			salt:      make([]byte, saltSize),
+			nonce:     make([]byte, noncePrefixSize),
+			chunkSize: defaultChunkSize,
+		}
+		assert.Nil(t, header.write(&w))
+
+		f, err := os.CreateTemp(t.TempDir(), "archive-*.aarch.enc")
+		assert.Nil(t, err)
+		defer f.Close()
+
+		_, err = f.Write(w.Bytes())
+		assert.Nil(t, err)
+
+		var out bytes.Buffer
+		assert.ErrorIs(t, ChangePassword(&out, f, "old", "new"), ErrUnsupportedVersion)
+	})
+}
@@ -0,0 +1,173 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafePath is matched by the UnsafePathError errors, using errors.Is.
+var ErrUnsafePath = fmt.Errorf("unsafe path")
+
+// An UnsafePathError is returned when an entry would be extracted outside of the
+// destination directory, like entries with absolute paths, ".." components or whose
+// path goes through a symbolic link that points outside of it.
+type UnsafePathError struct {
+	// Name is the name of the entry.
+	Name string
+	// Reason describes why the path is unsafe.
+	Reason string
+}
+
+func (e *UnsafePathError) Error() string {
+	return fmt.Sprintf("%v: %s (%s)", ErrUnsafePath, e.Name, e.Reason)
+}
+
+// Is reports whether target is ErrUnsafePath.
+func (e *UnsafePathError) Is(target error) bool {
+	return target == ErrUnsafePath
+}
+
+// CheckEntryName returns an *UnsafePathError if the entry name, turned into a path
+// of the current platform, isn't local: it's empty, absolute, has ".." components
+// that go above its root or is a reserved name, like "NUL" in Windows.
+func CheckEntryName(name string) error {
+	path := filepath.FromSlash(strings.TrimSuffix(name, "/"))
+
+	switch {
+	case filepath.IsAbs(path) || strings.HasPrefix(name, "/"):
+		return &UnsafePathError{Name: name, Reason: "absolute path"}
+	case containsDotDot(name):
+		return &UnsafePathError{Name: name, Reason: "path with .. components"}
+	case !filepath.IsLocal(path):
+		return &UnsafePathError{Name: name, Reason: "not a local path"}
+	}
+
+	return nil
+}
+
+// SafePath returns the path where the named entry is extracted into the root
+// directory. It returns an *UnsafePathError if the name isn't safe (see
+// CheckEntryName), or if the path goes through an existing symbolic link that points
+// outside of the root, which would make the entry be written outside of it.
+func SafePath(root, name string) (string, error) {
+	if err := CheckEntryName(name); err != nil {
+		return "", err
+	}
+
+	var (
+		relPath = filepath.FromSlash(strings.TrimSuffix(name, "/"))
+		path    = root
+	)
+
+	resolvedRoot, err := filepath.EvalSymlinks(root)
+	if errors.Is(err, os.ErrNotExist) {
+		// Nothing exists inside a missing root, so there are no links to follow
+		return filepath.Join(root, relPath), nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	resolvedRoot, err = filepath.Abs(resolvedRoot)
+	if err != nil {
+		return "", err
+	}
+
+	for _, component := range strings.Split(relPath, string(filepath.Separator)) {
+		path = filepath.Join(path, component)
+
+		info, err := os.Lstat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// The rest of the path doesn't exist, so it's created inside the root
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		target, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return "", &UnsafePathError{Name: name, Reason: "broken symbolic link"}
+		}
+
+		if target, err = filepath.Abs(target); err != nil {
+			return "", err
+		}
+
+		if !isWithin(resolvedRoot, target) {
+			return "", &UnsafePathError{Name: name, Reason: "symbolic link outside of the destination"}
+		}
+	}
+
+	return filepath.Join(root, relPath), nil
+}
+
+// UnsafePath returns the path where the named entry is extracted into the root
+// directory without any safety checks: absolute names are used as they are, and
+// relative names are joined to the root.
+func UnsafePath(root, name string) string {
+	path := filepath.FromSlash(name)
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(root, path)
+}
+
+// entryName returns the name of the entry for the file or directory at path: the
+// cleaned path without its volume name or leading slashes, so that it's relative,
+// using forward slashes as separators and ending with a slash for directories. It
+// returns an *UnsafePathError if the path has ".." components that go above its root,
+// as the entry couldn't be extracted.
+func entryName(path string, isDir bool) (string, error) {
+	cleaned := filepath.Clean(path)
+	cleaned = cleaned[len(filepath.VolumeName(cleaned)):]
+
+	name := strings.TrimLeft(filepath.ToSlash(cleaned), "/")
+	if name == "" {
+		name = "."
+	}
+
+	if containsDotDot(name) {
+		return "", &UnsafePathError{Name: filepath.ToSlash(path), Reason: "path with .. components"}
+	}
+
+	if isDir {
+		return dirName(name), nil
+	}
+
+	return name, nil
+}
+
+// containsDotDot returns whether the slash-separated name has ".." components.
+func containsDotDot(name string) bool {
+	for _, component := range strings.FieldsFunc(name, isSlash) {
+		if component == ".." {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isSlash returns whether r is a path separator, in any platform.
+func isSlash(r rune) bool {
+	return r == '/' || r == '\\'
+}
+
+// isWithin returns whether the absolute path is the root directory or inside it.
+func isWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,86 @@
+package archive
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckEntryName(t *testing.T) {
+	tests := []struct {
+		name   string
+		unsafe bool
+	}{
+		{"file.txt", false},
+		{"dir/", false},
+		{"dir/sub/file.txt", false},
+		{"dir/../file.txt", true},
+		{"../file.txt", true},
+		{"../../.ssh/authorized_keys", true},
+		{"dir/..", true},
+		{"/etc/passwd", true},
+		{"..\\file.txt", true},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckEntryName(tt.name)
+
+			if tt.unsafe {
+				assert.ErrorIs(t, err, ErrUnsafePath)
+
+				var pathErr *UnsafePathError
+				assert.ErrorAs(t, err, &pathErr)
+				assert.Equal(t, tt.name, pathErr.Name)
+			} else {
+				assert.Nil(t, err)
+			}
+		})
+	}
+}
+
+func TestSafePath(t *testing.T) {
+	var (
+		root    = t.TempDir()
+		outside = t.TempDir()
+	)
+
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0755); err != nil {
+		t.Fatalf("Error creating directory: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "escape")); err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+	if err := os.Symlink("dir", filepath.Join(root, "inside")); err != nil {
+		t.Fatalf("Error creating symbolic link: %v", err)
+	}
+
+	t.Run("inside the root", func(t *testing.T) {
+		path, err := SafePath(root, "dir/new/file.txt")
+
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(root, "dir", "new", "file.txt"), path)
+	})
+
+	t.Run("symbolic link inside the root", func(t *testing.T) {
+		path, err := SafePath(root, "inside/file.txt")
+
+		assert.Nil(t, err)
+		assert.Equal(t, filepath.Join(root, "inside", "file.txt"), path)
+	})
+
+	t.Run("symbolic link outside of the root", func(t *testing.T) {
+		_, err := SafePath(root, "escape/authorized_keys")
+
+		assert.ErrorIs(t, err, ErrUnsafePath)
+	})
+
+	t.Run("unsafe name", func(t *testing.T) {
+		_, err := SafePath(root, "../file.txt")
+
+		assert.ErrorIs(t, err, ErrUnsafePath)
+	})
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"hash"
+	"io"
+	"math"
+	"os"
+	"strings"
+)
+
+// A Reader reads an archive from an io.ReaderAt. Only the header is read up front;
+// the files' data is read, and decompressed, as it's streamed by the readers returned
+// by Open, so that files are never held in memory.
+type Reader struct {
+	r       io.ReaderAt
+	Header  *Header
+	entries map[string]*HeaderFileEntry
+}
+
+// NewReader creates a Reader for the archive in r, reading its header. Archives with
+// encrypted entries are unlocked with the identities, like in ReadHeader.
+func NewReader(r io.ReaderAt, identities ...Identity) (*Reader, error) {
+	header, err := ReadHeader(io.NewSectionReader(r, 0, math.MaxInt64), identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make(map[string]*HeaderFileEntry, len(header.Entries))
+	for _, entry := range header.Entries {
+		entries[entry.Name] = entry
+	}
+
+	return &Reader{
+		r:       r,
+		Header:  header,
+		entries: entries,
+	}, nil
+}
+
+// Entry returns the entry with the given name, or an ErrEntryNotFoundInHeader error
+// if the archive has no such entry.
+func (r *Reader) Entry(name string) (*HeaderFileEntry, error) {
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, ErrEntryNotFoundInHeader
+	}
+
+	return entry, nil
+}
+
+// Open returns a reader with the decompressed data of the named file. If the archive
+// has no such file, it returns an ErrEntryNotFoundInHeader error.
+// See OpenEntry for the details.
+func (r *Reader) Open(name string) (io.ReadCloser, error) {
+	entry, err := r.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.OpenEntry(entry)
+}
+
+// OpenEntry returns a reader with the decompressed data of the entry, which is read
+// from the archive as it's consumed. Directories have no data. If the entry has a
+// checksum, the data is verified against it once fully read, returning an
+// ErrChecksumMismatch error instead of io.EOF if it doesn't match.
+// In archives with FlagEncryptedEntries, the data is decrypted as it's read, returning
+// an ErrEncrypted error if the header is locked.
+// The returned reader must be closed.
+func (r *Reader) OpenEntry(entry *HeaderFileEntry) (io.ReadCloser, error) {
+	if entry.IsDir() {
+		return io.NopCloser(strings.NewReader("")), nil
+	}
+
+	var data io.Reader = r.OpenRaw(entry)
+	if entry.encryption != nil {
+		var err error
+		if data, err = entry.encryption.openData(data, entry.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	decompressor, err := newDecompressor(data, entry.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	return &checksumReader{
+		ReadCloser: decompressor,
+		name:       entry.Name,
+		want:       entry.Checksum,
+		hash:       sha256.New(),
+	}, nil
+}
+
+// UncompressedSize returns the size of the entry's uncompressed data. If the archive
+// doesn't store it, as in archives without FlagUncompressedSize, the entry is
+// decompressed to count its bytes, which also verifies its checksum.
+func (r *Reader) UncompressedSize(entry *HeaderFileEntry) (uint64, error) {
+	if r.Header.Flags&FlagUncompressedSize != 0 || entry.IsDir() {
+		return entry.UncompressedSize, nil
+	}
+
+	data, err := r.OpenEntry(entry)
+	if err != nil {
+		return 0, err
+	}
+	defer data.Close()
+
+	n, err := io.Copy(io.Discard, data)
+	return uint64(n), err
+}
+
+// OpenRaw returns a reader with the compressed data of the entry, as stored in the
+// archive, which is encrypted in archives with FlagEncryptedEntries.
+func (r *Reader) OpenRaw(entry *HeaderFileEntry) *io.SectionReader {
+	return io.NewSectionReader(r.r, int64(entry.Offset-1), int64(entry.Size))
+}
+
+// A ReadCloser is a Reader that reads the archive from a file, which must be closed.
+type ReadCloser struct {
+	*Reader
+	f *os.File
+}
+
+// OpenReader opens the archive file at the given path and creates a Reader for it,
+// unlocking it with the identities, like NewReader.
+func OpenReader(path string, identities ...Identity) (*ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := NewReader(f, identities...)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &ReadCloser{Reader: r, f: f}, nil
+}
+
+// Close closes the archive file.
+func (rc *ReadCloser) Close() error {
+	return rc.f.Close()
+}
+
+// checksumReader computes the checksum of the data read through it, and verifies it
+// against the expected one, if known, once the data is fully read.
+type checksumReader struct {
+	io.ReadCloser
+	name string
+	want Checksum
+	hash hash.Hash
+}
+
+func (c *checksumReader) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if err := c.want.verify(c.name, Checksum(c.hash.Sum(nil))); err != nil {
+			return n, err
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,111 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReader(t *testing.T) {
+	var (
+		fileOne    = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
+		fileTwo    = createTempFileForTest(t, "fileTwo.txt", "BBBBBBBB")
+		archive, _ = Create([]string{fileOne.FileName, fileTwo.FileName})
+		arBytes    = new(bytes.Buffer)
+	)
+
+	archive.Write(arBytes)
+
+	reader, err := NewReader(bytes.NewReader(arBytes.Bytes()))
+	assert.Nil(t, err)
+
+	t.Run("header", func(t *testing.T) {
+		assert.Equal(t, archive.Header, reader.Header)
+	})
+
+	t.Run("open fileTwo", func(t *testing.T) {
+		rc, err := reader.Open(entryNameForTest(t, fileTwo.FileName))
+		assert.Nil(t, err)
+		defer rc.Close()
+
+		got, err := io.ReadAll(rc)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("BBBBBBBB"), got)
+	})
+
+	t.Run("open raw fileOne", func(t *testing.T) {
+		entry, _ := reader.Entry(entryNameForTest(t, fileOne.FileName))
+
+		got, err := io.ReadAll(reader.OpenRaw(entry))
+		assert.Nil(t, err)
+		assert.Equal(t, fileOne.CompressedBytes, got)
+	})
+
+	t.Run("open missing file", func(t *testing.T) {
+		_, err := reader.Open("missing.txt")
+
+		assert.ErrorIs(t, err, ErrEntryNotFoundInHeader)
+	})
+
+	t.Run("checksum mismatch", func(t *testing.T) {
+		entry := *archive.Header.Entries[0]
+		entry.Checksum = NewChecksum([]byte("CCCCCCCC"))
+
+		rc, err := reader.OpenEntry(&entry)
+		assert.Nil(t, err)
+		defer rc.Close()
+
+		_, err = io.ReadAll(rc)
+		assert.ErrorIs(t, err, ErrChecksumMismatch)
+	})
+}
+
+func TestReaderOpenDirectory(t *testing.T) {
+	reader := &Reader{}
+
+	rc, err := reader.OpenEntry(NewHeaderFileEntry("dir/", 0))
+	assert.Nil(t, err)
+
+	got, err := io.ReadAll(rc)
+	assert.Nil(t, err)
+	assert.Empty(t, got)
+}
+
+func TestReaderUncompressedSize(t *testing.T) {
+	t.Run("stored", func(t *testing.T) {
+		reader := newTestReader(t, []testEntry{{name: "one.txt", content: "AAAAAAAA"}})
+		entry, _ := reader.Entry("one.txt")
+
+		size, err := reader.UncompressedSize(entry)
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(8), size)
+		assert.Equal(t, uint64(8), entry.UncompressedSize)
+	})
+
+	t.Run("not stored", func(t *testing.T) {
+		var (
+			fileOne    = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
+			archive, _ = Create([]string{fileOne.FileName})
+			arBytes    = new(bytes.Buffer)
+		)
+
+		// Rewrite the archive as v1, which doesn't store the uncompressed sizes
+		header := archive.Header
+		header.Version, header.Flags = FormatV1, 0
+		header.HeaderLength = FormatV1.preambleLen() + header.Entries[0].totalBytes(FormatV1, 0)
+		header.Entries[0].Offset = header.HeaderLength + 1
+		archive.Write(arBytes)
+
+		reader, err := NewReader(bytes.NewReader(arBytes.Bytes()))
+		assert.Nil(t, err)
+
+		size, err := reader.UncompressedSize(reader.Header.Entries[0])
+
+		assert.Nil(t, err)
+		assert.Equal(t, uint64(8), size)
+		assert.Equal(t, uint64(0), reader.Header.Entries[0].UncompressedSize)
+	})
+}
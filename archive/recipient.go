@@ -0,0 +1,370 @@
+package archive
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// ErrInvalidKey is returned when parsing a malformed public or secret key.
+var ErrInvalidKey = fmt.Errorf("invalid key")
+
+// ErrNoMatchingIdentity is returned when none of the identities can unwrap the data key
+// of an encrypted archive, because it wasn't encrypted to them.
+var ErrNoMatchingIdentity = fmt.Errorf("archive not encrypted to any of the identities")
+
+// dataKeySize is the size of the random key that encrypts the data of an archive.
+const dataKeySize = 32
+
+// The kinds of key stanzas, which identify how the data key is wrapped.
+const (
+	stanzaX25519   uint8 = 1
+	stanzaPassword uint8 = 2
+)
+
+// A keyStanza is the data key of an encrypted archive, wrapped for one recipient.
+type keyStanza struct {
+	kind uint8
+	body []byte
+}
+
+// A Recipient wraps the data key of an encrypted archive, so that only the matching
+// Identity can unwrap it.
+type Recipient interface {
+	wrapKey(dataKey []byte) (*keyStanza, error)
+}
+
+// An Identity unwraps the data key of an encrypted archive from the stanza of the
+// matching Recipient. It returns an ErrNoMatchingIdentity error for other stanzas.
+type Identity interface {
+	unwrapKey(stanza *keyStanza) ([]byte, error)
+}
+
+// The prefixes of the textual X25519 keys.
+const (
+	x25519PublicPrefix = "aarpub-"
+	x25519SecretPrefix = "AAR-SECRET-KEY-"
+)
+
+// x25519Info binds the keys derived to wrap the data key to their purpose.
+var x25519Info = []byte("aar x25519 data key")
+
+// An X25519Recipient encrypts archives to an X25519 public key.
+type X25519Recipient struct {
+	key *ecdh.PublicKey
+}
+
+// ParseX25519Recipient parses the public key of an X25519Recipient, as returned by its
+// String method.
+func ParseX25519Recipient(s string) (*X25519Recipient, error) {
+	data, err := decodeKey(s, x25519PublicPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdh.X25519().NewPublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return &X25519Recipient{key: key}, nil
+}
+
+// ParseRecipients parses the X25519 recipients in r, one per line. Empty lines and lines
+// starting with # are ignored.
+func ParseRecipients(r io.Reader) ([]Recipient, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	recipients := make([]Recipient, len(lines))
+	for i, line := range lines {
+		if recipients[i], err = ParseX25519Recipient(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return recipients, nil
+}
+
+// String returns the recipient's public key, which can be shared.
+func (r *X25519Recipient) String() string {
+	return x25519PublicPrefix + base64.RawURLEncoding.EncodeToString(r.key.Bytes())
+}
+
+// wrapKey encrypts the data key with a key agreed between an ephemeral key and the
+// recipient's key. The stanza holds the ephemeral public key and the wrapped key.
+func (r *X25519Recipient) wrapKey(dataKey []byte) (*keyStanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := ephemeral.ECDH(r.key)
+	if err != nil {
+		return nil, err
+	}
+
+	ephemeralPublic := ephemeral.PublicKey().Bytes()
+	aead, err := x25519WrapCipher(shared, ephemeralPublic, r.key.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	// The wrapping key is used only once, so the nonce can be fixed
+	body := aead.Seal(ephemeralPublic, make([]byte, nonceSize), dataKey, nil)
+	return &keyStanza{kind: stanzaX25519, body: body}, nil
+}
+
+// An X25519Identity decrypts the archives encrypted to its X25519Recipient.
+type X25519Identity struct {
+	key *ecdh.PrivateKey
+}
+
+// GenerateX25519Identity generates a new random identity.
+func GenerateX25519Identity() (*X25519Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseX25519Identity parses the secret key of an X25519Identity, as returned by its
+// String method.
+func ParseX25519Identity(s string) (*X25519Identity, error) {
+	data, err := decodeKey(s, x25519SecretPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := ecdh.X25519().NewPrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return &X25519Identity{key: key}, nil
+}
+
+// ParseIdentities parses the X25519 identities in r, one per line. Empty lines and lines
+// starting with # are ignored.
+func ParseIdentities(r io.Reader) ([]Identity, error) {
+	lines, err := keyLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	identities := make([]Identity, len(lines))
+	for i, line := range lines {
+		if identities[i], err = ParseX25519Identity(line); err != nil {
+			return nil, err
+		}
+	}
+
+	return identities, nil
+}
+
+// keyLines returns the lines of r with keys, skipping the empty lines and comments.
+// It returns an ErrInvalidKey error if there are none.
+func keyLines(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%w: no keys found", ErrInvalidKey)
+	}
+
+	return lines, nil
+}
+
+// String returns the identity's secret key, which must be kept private.
+func (i *X25519Identity) String() string {
+	return x25519SecretPrefix + base64.RawURLEncoding.EncodeToString(i.key.Bytes())
+}
+
+// Recipient returns the recipient whose archives the identity decrypts.
+func (i *X25519Identity) Recipient() *X25519Recipient {
+	return &X25519Recipient{key: i.key.PublicKey()}
+}
+
+// unwrapKey decrypts the data key wrapped by the identity's recipient.
+func (i *X25519Identity) unwrapKey(stanza *keyStanza) ([]byte, error) {
+	if stanza.kind != stanzaX25519 || len(stanza.body) != 32+dataKeySize+16 {
+		return nil, ErrNoMatchingIdentity
+	}
+
+	ephemeral, err := ecdh.X25519().NewPublicKey(stanza.body[:32])
+	if err != nil {
+		return nil, ErrNoMatchingIdentity
+	}
+
+	shared, err := i.key.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrNoMatchingIdentity
+	}
+
+	aead, err := x25519WrapCipher(shared, stanza.body[:32], i.key.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	dataKey, err := aead.Open(nil, make([]byte, nonceSize), stanza.body[32:], nil)
+	if err != nil {
+		return nil, ErrNoMatchingIdentity
+	}
+
+	return dataKey, nil
+}
+
+// x25519WrapCipher returns the AES-GCM cipher that wraps the data key, with the key
+// derived from the shared secret and both public keys.
+func x25519WrapCipher(shared, ephemeralPublic, recipientPublic []byte) (cipher.AEAD, error) {
+	salt := append(append([]byte{}, ephemeralPublic...), recipientPublic...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, x25519Info), key); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// decodeKey decodes the textual key with the given prefix.
+func decodeKey(s, prefix string) ([]byte, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(s), prefix)
+	if !ok {
+		return nil, fmt.Errorf("%w: expected the %s prefix", ErrInvalidKey, prefix)
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	return data, nil
+}
+
+// wrapDataKey wraps the data key for each of the recipients.
+func wrapDataKey(dataKey []byte, recipients []Recipient) ([]*keyStanza, error) {
+	stanzas := make([]*keyStanza, len(recipients))
+	for i, recipient := range recipients {
+		stanza, err := recipient.wrapKey(dataKey)
+		if err != nil {
+			return nil, err
+		}
+
+		stanzas[i] = stanza
+	}
+
+	return stanzas, nil
+}
+
+// unwrapDataKey unwraps the data key from the first stanza that one of the identities
+// can unwrap. Every stanza is tried before failing: the first error other than
+// ErrNoMatchingIdentity, like an ErrDecryptionFailed error for a wrong password, is
+// returned, or an ErrNoMatchingIdentity error if there's none.
+func unwrapDataKey(stanzas []*keyStanza, identities []Identity) ([]byte, error) {
+	var unwrapErr error
+
+	for _, identity := range identities {
+		for _, stanza := range stanzas {
+			dataKey, err := identity.unwrapKey(stanza)
+			if err == nil {
+				return dataKey, nil
+			}
+
+			if unwrapErr == nil && !errors.Is(err, ErrNoMatchingIdentity) {
+				unwrapErr = err
+			}
+		}
+	}
+
+	if unwrapErr != nil {
+		return nil, unwrapErr
+	}
+
+	return nil, ErrNoMatchingIdentity
+}
+
+// maxStanzaSize caps the size of the key stanzas read from an encrypted archive.
+const maxStanzaSize = 4 << 10
+
+// writeKeyStanzas writes the number of stanzas (2 bytes) and, for each of them, its kind
+// (1 byte), the length of its body (2 bytes) and its body.
+func writeKeyStanzas(w io.Writer, stanzas []*keyStanza) error {
+	var buf bytes.Buffer
+
+	binary.Write(&buf, byteOrder, uint16(len(stanzas)))
+	for _, stanza := range stanzas {
+		buf.WriteByte(stanza.kind)
+		binary.Write(&buf, byteOrder, uint16(len(stanza.body)))
+		buf.Write(stanza.body)
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// readKeyStanzas reads the stanzas written by writeKeyStanzas from r.
+func readKeyStanzas(r io.Reader) ([]*keyStanza, error) {
+	var count uint16
+	if err := binary.Read(r, byteOrder, &count); err != nil {
+		return nil, err
+	}
+
+	if count == 0 {
+		return nil, fmt.Errorf("encrypted archive without recipients")
+	}
+
+	stanzas := make([]*keyStanza, count)
+	for i := range stanzas {
+		var header struct {
+			Kind uint8
+			Len  uint16
+		}
+		if err := binary.Read(r, byteOrder, &header); err != nil {
+			return nil, err
+		}
+
+		if header.Len > maxStanzaSize {
+			return nil, fmt.Errorf("invalid key stanza length: %d bytes", header.Len)
+		}
+
+		body := make([]byte, header.Len)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+
+		stanzas[i] = &keyStanza{kind: header.Kind, body: body}
+	}
+
+	return stanzas, nil
+}
@@ -0,0 +1,96 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestX25519Keys(t *testing.T) {
+	identity, err := GenerateX25519Identity()
+	assert.Nil(t, err)
+
+	parsedIdentity, err := ParseX25519Identity(identity.String())
+	assert.Nil(t, err)
+	assert.Equal(t, identity.String(), parsedIdentity.String())
+
+	recipient, err := ParseX25519Recipient(identity.Recipient().String())
+	assert.Nil(t, err)
+	assert.Equal(t, identity.Recipient().String(), recipient.String())
+
+	for _, key := range []string{"", "aarpub-", "aarpub-!!", identity.String()} {
+		_, err := ParseX25519Recipient(key)
+		assert.ErrorIs(t, err, ErrInvalidKey)
+	}
+
+	_, err = ParseX25519Identity(recipient.String())
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestParseIdentities(t *testing.T) {
+	identity, _ := GenerateX25519Identity()
+	file := "# created by aar keygen\n# public key: " + identity.Recipient().String() + "\n\n" + identity.String() + "\n"
+
+	identities, err := ParseIdentities(strings.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, []Identity{identity}, identities)
+
+	_, err = ParseIdentities(strings.NewReader("# nothing here\n"))
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestParseRecipients(t *testing.T) {
+	alice, _ := GenerateX25519Identity()
+	bob, _ := GenerateX25519Identity()
+	file := "# team\n" + alice.Recipient().String() + "\n" + bob.Recipient().String() + "\n"
+
+	recipients, err := ParseRecipients(strings.NewReader(file))
+	assert.Nil(t, err)
+	assert.Equal(t, []Recipient{alice.Recipient(), bob.Recipient()}, recipients)
+}
+
+func TestEncryptToRecipients(t *testing.T) {
+	var (
+		alice, _  = GenerateX25519Identity()
+		bob, _    = GenerateX25519Identity()
+		eve, _    = GenerateX25519Identity()
+		data      = bytes.Repeat([]byte("shared archive data "), 10_000)
+		encrypted bytes.Buffer
+	)
+
+	w, err := EncryptTo(&encrypted, alice.Recipient(), bob.Recipient())
+	assert.Nil(t, err)
+
+	_, err = w.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, w.Close())
+
+	for name, identity := range map[string]*X25519Identity{"alice": alice, "bob": bob} {
+		t.Run(name, func(t *testing.T) {
+			r, err := DecryptWith(bytes.NewReader(encrypted.Bytes()), identity)
+			assert.Nil(t, err)
+
+			decrypted, err := io.ReadAll(r)
+			assert.Nil(t, err)
+			assert.Equal(t, data, decrypted)
+		})
+	}
+
+	t.Run("other identity", func(t *testing.T) {
+		_, err := DecryptWith(bytes.NewReader(encrypted.Bytes()), eve)
+		assert.ErrorIs(t, err, ErrNoMatchingIdentity)
+	})
+
+	t.Run("password", func(t *testing.T) {
+		_, err := NewDecryptReader(bytes.NewReader(encrypted.Bytes()), "password")
+		assert.ErrorIs(t, err, ErrNoMatchingIdentity)
+	})
+
+	t.Run("no recipients", func(t *testing.T) {
+		_, err := EncryptTo(io.Discard)
+		assert.NotNil(t, err)
+	})
+}
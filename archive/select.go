@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Select returns the entries matching any of the include patterns, but none of the
+// exclude patterns, in the order they're stored in the archive. With no include
+// patterns, all the entries are included.
+//
+// Patterns use the syntax of path.Match and are matched against the entries' names,
+// without the trailing slash of directories. Matching a directory also matches all
+// the entries inside it. Exclude patterns without slashes are also matched against the
+// entries' base names, so that "*.tmp" excludes those files in any directory.
+//
+// It returns an ErrEntryNotFoundInHeader error if an include pattern matches no entry,
+// and a path.ErrBadPattern error if a pattern is malformed.
+func (h *Header) Select(include, exclude []string) ([]*HeaderFileEntry, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("%w: %s", err, pattern)
+		}
+	}
+
+	var (
+		selected []*HeaderFileEntry
+		matched  = make([]bool, len(include))
+	)
+
+	for _, entry := range h.Entries {
+		included := len(include) == 0
+		for i, pattern := range include {
+			if matchEntry(pattern, entry.Name, false) {
+				matched[i] = true
+				included = true
+			}
+		}
+
+		if included && !matchesAny(exclude, entry.Name) {
+			selected = append(selected, entry)
+		}
+	}
+
+	var errs []error
+	for i, pattern := range include {
+		if !matched[i] {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrEntryNotFoundInHeader, pattern))
+		}
+	}
+
+	return selected, errors.Join(errs...)
+}
+
+// matchesAny returns whether the entry name matches any of the exclude patterns.
+func matchesAny(exclude []string, name string) bool {
+	for _, pattern := range exclude {
+		if matchEntry(pattern, name, true) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchEntry returns whether the pattern matches the entry name or any of its parent
+// directories. Unanchored patterns, without slashes, are also matched against the
+// base names when matchBase is set.
+func matchEntry(pattern, name string, matchBase bool) bool {
+	pattern = strings.TrimSuffix(pattern, "/")
+	name = strings.TrimSuffix(name, "/")
+	matchBase = matchBase && !strings.Contains(pattern, "/")
+
+	for name != "." && name != "/" && name != "" {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(name)); ok && matchBase {
+			return true
+		}
+
+		name = path.Dir(name)
+	}
+
+	return false
+}
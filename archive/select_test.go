@@ -0,0 +1,67 @@
+package archive
+
+import (
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelect(t *testing.T) {
+	header := &Header{
+		Entries: []*HeaderFileEntry{
+			{Name: "config.yaml"},
+			{Name: "logs/"},
+			{Name: "logs/app.log"},
+			{Name: "logs/app.tmp"},
+			{Name: "logs/old/app.log"},
+			{Name: "src/main.go"},
+			{Name: "src/main.tmp"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		include []string
+		exclude []string
+		want    []string
+	}{
+		{"all", nil, nil, []string{
+			"config.yaml", "logs/", "logs/app.log", "logs/app.tmp", "logs/old/app.log",
+			"src/main.go", "src/main.tmp",
+		}},
+		{"names", []string{"src/main.go", "config.yaml"}, nil, []string{"config.yaml", "src/main.go"}},
+		{"glob", []string{"logs/*.log"}, nil, []string{"logs/app.log"}},
+		{"directory", []string{"logs"}, nil, []string{"logs/", "logs/app.log", "logs/app.tmp", "logs/old/app.log"}},
+		{"exclude base names", nil, []string{"*.tmp", "logs/old"}, []string{
+			"config.yaml", "logs/", "logs/app.log", "src/main.go",
+		}},
+		{"include and exclude", []string{"src"}, []string{"src/*.go"}, []string{"src/main.tmp"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entries, err := header.Select(tt.include, tt.exclude)
+			assert.Nil(t, err)
+
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name)
+			}
+			assert.Equal(t, tt.want, names)
+		})
+	}
+
+	t.Run("pattern without matches", func(t *testing.T) {
+		_, err := header.Select([]string{"config.yaml", "*.json"}, nil)
+
+		assert.ErrorIs(t, err, ErrEntryNotFoundInHeader)
+		assert.Contains(t, err.Error(), "*.json")
+	})
+
+	t.Run("bad pattern", func(t *testing.T) {
+		_, err := header.Select(nil, []string{"[a-"})
+
+		assert.ErrorIs(t, err, path.ErrBadPattern)
+	})
+}
@@ -0,0 +1,425 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrUnknownEntry is returned when writing a file that isn't in the Writer's entries.
+var ErrUnknownEntry = fmt.Errorf("file not in the archive entries")
+
+// ErrEntryAlreadyWritten is returned when writing a file more than once.
+var ErrEntryAlreadyWritten = fmt.Errorf("file already written to the archive")
+
+// ErrMissingEntryData is returned when closing a Writer before writing all the files.
+var ErrMissingEntryData = fmt.Errorf("file not written to the archive")
+
+// A Writer writes an archive into an io.WriteSeeker, compressing each file straight
+// into it, so that files are never held in memory.
+//
+// As the header goes before the files' data, the entries must be known in advance to
+// reserve room for it. Once all the files have been written, closing the Writer
+// back-patches the header with their offsets, sizes and checksums.
+type Writer struct {
+	ws      io.WriteSeeker
+	header  *Header
+	entries map[string]*HeaderFileEntry
+	written map[string]bool
+	start   int64
+	offset  uint64
+}
+
+// NewWriter creates a Writer that writes an archive with the given entries into ws,
+// starting at its current position. The entries' names and metadata are used as is,
+// and their offsets, sizes and checksums are set as the files are written.
+func NewWriter(ws io.WriteSeeker, entries []*HeaderFileEntry) (*Writer, error) {
+	return newWriter(ws, newHeader(entries))
+}
+
+// NewEncryptedWriter is like NewWriter, but it encrypts each file's compressed data
+// separately with a random data key, wrapped for each of the recipients, setting
+// FlagEncryptedEntries. With encryptHeader, the file entries are encrypted too, setting
+// FlagEncryptedHeader.
+func NewEncryptedWriter(ws io.WriteSeeker, entries []*HeaderFileEntry, encryptHeader bool, recipients ...Recipient) (*Writer, error) {
+	encryption, err := newEntryEncryption(recipients)
+	if err != nil {
+		return nil, err
+	}
+
+	header := newHeader(entries)
+	header.setEncryption(encryption, encryptHeader)
+
+	return newWriter(ws, header)
+}
+
+// newWriterFor is like NewWriter, but it encrypts the archive like the one with the src
+// header, if it has encrypted entries, reusing its data key, so that their data can be
+// copied as is.
+func newWriterFor(ws io.WriteSeeker, entries []*HeaderFileEntry, src *Header) (*Writer, error) {
+	header := newHeader(entries)
+	if src.encryption != nil {
+		header.setEncryption(src.encryption, src.Flags&FlagEncryptedHeader != 0)
+	}
+
+	return newWriter(ws, header)
+}
+
+// newWriter creates a Writer that writes an archive with the header into ws.
+func newWriter(ws io.WriteSeeker, header *Header) (*Writer, error) {
+	w := &Writer{
+		ws:      ws,
+		header:  header,
+		entries: make(map[string]*HeaderFileEntry, len(header.Entries)),
+		written: make(map[string]bool, len(header.Entries)),
+	}
+
+	entries := header.Entries
+
+	for _, entry := range entries {
+		w.entries[entry.Name] = entry
+	}
+
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+
+	// Write a placeholder header to reserve its room
+	if err := w.header.Write(ws); err != nil {
+		return nil, err
+	}
+
+	w.start = start
+	w.offset = w.header.HeaderLength + 1
+
+	return w, nil
+}
+
+// WriteFile reads the named file's data from r until EOF, compressing it into the
+// archive with the entry's compression method and options, and computing its checksum.
+// In archives with encrypted entries, the compressed data is encrypted as it's written,
+// and the checksum is only stored if the entries are encrypted too.
+func (w *Writer) WriteFile(name string, r io.Reader) error {
+	entry, ok := w.entries[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEntry, name)
+	}
+
+	if w.written[name] {
+		return fmt.Errorf("%w: %s", ErrEntryAlreadyWritten, name)
+	}
+
+	var (
+		counter = &countingWriter{w: w.ws}
+		hash    = sha256.New()
+	)
+
+	var sealer io.WriteCloser = nopWriteCloser{counter}
+	if w.header.encryption != nil {
+		var err error
+		if sealer, err = w.header.encryption.sealData(counter, name); err != nil {
+			return err
+		}
+	}
+
+	opts := CompressorOptions{Level: entry.Level, DictSize: entry.DictSize}
+	compressor, err := newCompressor(sealer, entry.Method, opts)
+	if err != nil {
+		return err
+	}
+
+	n, err := io.Copy(io.MultiWriter(compressor, hash), r)
+	if err != nil {
+		return err
+	}
+
+	if err := compressor.Close(); err != nil {
+		return err
+	}
+
+	if err := sealer.Close(); err != nil {
+		return err
+	}
+
+	entry.Offset = w.offset
+	entry.Size = counter.n
+	entry.Checksum = Checksum(hash.Sum(nil))
+	if w.header.hidesChecksums() {
+		entry.Checksum = Checksum{}
+	}
+	entry.UncompressedSize = uint64(n)
+	if entry.Method == MethodXZ {
+		// Record the dictionary size actually used
+		entry.DictSize = xzDictSize(opts)
+	}
+	w.offset += counter.n
+	w.written[name] = true
+
+	return nil
+}
+
+// WriteRaw copies the named file's already compressed data from r until EOF into the
+// archive, as is, storing the checksum, uncompressed size, compression method and
+// options of the src entry, which the data comes from. In archives with encrypted
+// entries, the data must be encrypted with the same data key.
+func (w *Writer) WriteRaw(name string, r io.Reader, src *HeaderFileEntry) error {
+	return w.writeRaw(name, r, src, false)
+}
+
+// writeRaw is like WriteRaw but, with reseal, the src entry's encrypted data is
+// decrypted and encrypted again for the named file, as its encryption authenticates
+// the file's name.
+func (w *Writer) writeRaw(name string, r io.Reader, src *HeaderFileEntry, reseal bool) error {
+	entry, ok := w.entries[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEntry, name)
+	}
+
+	if w.written[name] {
+		return fmt.Errorf("%w: %s", ErrEntryAlreadyWritten, name)
+	}
+
+	counter := &countingWriter{w: w.ws}
+
+	var sealer io.WriteCloser = nopWriteCloser{counter}
+	if reseal {
+		var err error
+		if r, err = src.encryption.openData(r, src.Name); err != nil {
+			return err
+		}
+
+		if sealer, err = w.header.encryption.sealData(counter, name); err != nil {
+			return err
+		}
+	}
+
+	if _, err := io.Copy(sealer, r); err != nil {
+		return err
+	}
+
+	if err := sealer.Close(); err != nil {
+		return err
+	}
+
+	n := counter.n
+	entry.Offset = w.offset
+	entry.Size = n
+	entry.Checksum = src.Checksum
+	entry.UncompressedSize = src.UncompressedSize
+	entry.Method = src.Method
+	entry.Level = src.Level
+	entry.DictSize = src.DictSize
+	w.offset += n
+	w.written[name] = true
+
+	return nil
+}
+
+// Close writes the header with the final offsets, sizes and checksums, leaving ws
+// positioned at the end of the archive. Directories don't need to be written, but
+// a ErrMissingEntryData error is returned if any other file wasn't.
+// It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	for _, entry := range w.header.Entries {
+		if w.written[entry.Name] {
+			continue
+		}
+
+		if !entry.IsDir() {
+			return fmt.Errorf("%w: %s", ErrMissingEntryData, entry.Name)
+		}
+
+		entry.Offset = w.offset
+		entry.Size = 0
+	}
+
+	if _, err := w.ws.Seek(w.start, io.SeekStart); err != nil {
+		return err
+	}
+
+	if err := w.header.Write(w.ws); err != nil {
+		return err
+	}
+
+	_, err := w.ws.Seek(w.start+int64(w.offset-1), io.SeekStart)
+	return err
+}
+
+// Header returns the archive's header.
+// The offsets, sizes and checksums are only complete once the Writer is closed.
+func (w *Writer) Header() *Header {
+	return w.header
+}
+
+// CreateStream writes an archive with the files in the provided paths into ws, one
+// file at a time, without holding them in memory. Directories are walked recursively,
+// like in Create. It returns the header of the written archive.
+func CreateStream(ws io.WriteSeeker, filePaths []string) (*Header, error) {
+	return CreateStreamFrom(ws, filePaths, nil, CreateOptions{})
+}
+
+// CreateOptions configures how the files are written into a new archive.
+type CreateOptions struct {
+	// Compression is the method used to compress the files. The default is MethodXZ.
+	Compression CompressionMethod
+	// Level is the compression preset. The default is each method's default.
+	Level CompressionLevel
+	// DictSize is the xz dictionary size in bytes, overriding the one of the preset.
+	// It can only be set with MethodXZ.
+	DictSize uint32
+	// Recipients encrypt each file's compressed data separately, so that the files can
+	// be listed and read one at a time. Use NewPasswordRecipient to encrypt them with a
+	// password. The default is not to encrypt them.
+	Recipients []Recipient
+	// EncryptHeader also encrypts the file entries, hiding the files' names and
+	// attributes. It needs Recipients.
+	EncryptHeader bool
+	// OnSkip, if set, is called with the path of each symbolic link or other file that
+	// isn't a regular file or directory, which are skipped.
+	OnSkip func(path string)
+}
+
+// A NamedReader is a file whose data is read from a reader, like the standard input,
+// to be added to an archive with the given name and metadata.
+type NamedReader struct {
+	Name string
+	Metadata
+	io.Reader
+}
+
+// CreateStreamFrom is like CreateStream, but it also adds the files read from the
+// readers, after the ones in the paths, and writes them using the options. Each reader
+// is read until EOF. Adding two files with the same name returns an ErrDuplicateEntry
+// error, and readers can't be named like directories, which returns an
+// ErrInvalidEntryName error.
+func CreateStreamFrom(ws io.WriteSeeker, filePaths []string, readers []*NamedReader, opts CreateOptions) (*Header, error) {
+	if _, err := codecFor(opts.Compression); err != nil {
+		return nil, err
+	}
+
+	compressorOpts := CompressorOptions{Level: opts.Level, DictSize: opts.DictSize}
+	if err := checkCompressorOptions(opts.Compression, compressorOpts); err != nil {
+		return nil, err
+	}
+
+	if opts.EncryptHeader && len(opts.Recipients) == 0 {
+		return nil, fmt.Errorf("no recipients to encrypt the header to")
+	}
+
+	walkedPaths, err := walkPaths(filePaths, opts.OnSkip)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		entries = make([]*HeaderFileEntry, len(walkedPaths), len(walkedPaths)+len(readers))
+		names   = make(map[string]bool, cap(entries))
+	)
+
+	for i, path := range walkedPaths {
+		if entries[i], err = NewHeaderFileEntryFromPath(path); err != nil {
+			return nil, err
+		}
+
+		names[entries[i].Name] = true
+	}
+
+	for _, reader := range readers {
+		if reader.Name == "" || isDirName(reader.Name) {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidEntryName, reader.Name)
+		}
+
+		if names[reader.Name] {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicateEntry, reader.Name)
+		}
+
+		entry := NewHeaderFileEntry(reader.Name, 0)
+		entry.Metadata = reader.Metadata
+		entries = append(entries, entry)
+		names[reader.Name] = true
+	}
+
+	for _, entry := range entries {
+		entry.Method = opts.Compression
+		entry.Level = compressorOpts.Level
+		entry.DictSize = compressorOpts.DictSize
+	}
+
+	var w *Writer
+	if len(opts.Recipients) > 0 {
+		w, err = NewEncryptedWriter(ws, entries, opts.EncryptHeader, opts.Recipients...)
+	} else {
+		w, err = NewWriter(ws, entries)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for i, entry := range entries[:len(walkedPaths)] {
+		if entry.IsDir() {
+			continue
+		}
+
+		if err := writeFileFromPath(w, entry.Name, walkedPaths[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, reader := range readers {
+		if err := w.WriteFile(reader.Name, reader.Reader); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return w.Header(), nil
+}
+
+// NewHeaderFileEntryFromPath creates a new header file entry for the file or directory
+// at the given path, with its metadata. Its name is the relative, cleaned path, using
+// forward slashes as separators and ending with a slash for directories, and paths
+// with ".." components return an *UnsafePathError.
+func NewHeaderFileEntryFromPath(path string) (*HeaderFileEntry, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, err := entryName(path, info.IsDir())
+	if err != nil {
+		return nil, err
+	}
+
+	entry := NewHeaderFileEntry(name, 0)
+	entry.Metadata = metadataFromFileInfo(info)
+
+	return entry, nil
+}
+
+// writeFileFromPath writes the named file into the Writer, reading it from the path.
+func writeFileFromPath(w *Writer, name, path string) error {
+	reader, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	return w.WriteFile(name, reader)
+}
+
+// countingWriter is a writer that counts the number of bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,212 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateStream(t *testing.T) {
+	var (
+		fileOne = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
+		fileTwo = createTempFileForTest(t, "fileTwo.txt", "BBBBBBBB")
+		paths   = []string{fileOne.FileName, fileTwo.FileName}
+		outPath = filepath.Join(t.TempDir(), "out.aarch")
+	)
+
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	defer outFile.Close()
+
+	header, err := CreateStream(outFile, paths)
+	assert.Nil(t, err)
+
+	t.Run("same archive as Create", func(t *testing.T) {
+		var (
+			archive, _ = Create(paths)
+			want       = new(bytes.Buffer)
+		)
+		archive.Write(want)
+
+		got, err := os.ReadFile(outPath)
+
+		assert.Nil(t, err)
+		assert.Equal(t, want.Bytes(), got)
+		assert.Equal(t, archive.Header, header)
+	})
+
+	t.Run("positioned at the end", func(t *testing.T) {
+		pos, _ := outFile.Seek(0, io.SeekCurrent)
+		assert.Equal(t, int64(header.TotalSize()), pos)
+	})
+}
+
+func TestCreateStreamFrom(t *testing.T) {
+	var (
+		fileOne  = createTempFileForTest(t, "fileOne.txt", "AAAAAAAA")
+		metadata = Metadata{Mode: 0644, ModTime: time.Unix(1700000000, 0)}
+		outPath  = filepath.Join(t.TempDir(), "out.aarch")
+	)
+
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	defer outFile.Close()
+
+	header, err := CreateStreamFrom(outFile, []string{fileOne.FileName}, []*NamedReader{
+		{Name: "dump.sql", Metadata: metadata, Reader: strings.NewReader("BBBBBBBB")},
+	}, CreateOptions{})
+	assert.Nil(t, err)
+
+	reader, err := NewReader(outFile)
+	assert.Nil(t, err)
+	assert.Equal(t, header, reader.Header)
+	assert.Equal(t, 2, len(header.Entries))
+	assert.Equal(t, "dump.sql", header.Entries[1].Name)
+	assert.Equal(t, metadata, header.Entries[1].Metadata)
+
+	rc, err := reader.Open("dump.sql")
+	assert.Nil(t, err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("BBBBBBBB"), data)
+
+	t.Run("duplicate name", func(t *testing.T) {
+		_, err := CreateStreamFrom(outFile, []string{fileOne.FileName}, []*NamedReader{
+			{Name: entryNameForTest(t, fileOne.FileName), Reader: strings.NewReader("")},
+		}, CreateOptions{})
+
+		assert.ErrorIs(t, err, ErrDuplicateEntry)
+	})
+
+	t.Run("directory name", func(t *testing.T) {
+		_, err := CreateStreamFrom(outFile, nil, []*NamedReader{
+			{Name: "dir/", Reader: strings.NewReader("")},
+		}, CreateOptions{})
+
+		assert.ErrorIs(t, err, ErrInvalidEntryName)
+	})
+}
+
+func TestCreateStreamSkipsSymlinks(t *testing.T) {
+	var (
+		root    = filepath.Join(t.TempDir(), "src")
+		outPath = filepath.Join(t.TempDir(), "out.aarch")
+	)
+
+	os.MkdirAll(filepath.Join(root, "dir"), 0755)
+	os.WriteFile(filepath.Join(root, "dir", "one.txt"), []byte("AAAAAAAA"), 0644)
+	if err := os.Symlink("dir", filepath.Join(root, "linkdir")); err != nil {
+		t.Skipf("Symbolic links not supported: %v", err)
+	}
+	os.Symlink("missing", filepath.Join(root, "dangling"))
+
+	outFile, err := os.Create(outPath)
+	if err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	defer outFile.Close()
+
+	var skipped []string
+	header, err := CreateStreamFrom(outFile, []string{root}, nil, CreateOptions{
+		OnSkip: func(path string) { skipped = append(skipped, path) },
+	})
+	assert.Nil(t, err)
+
+	var names []string
+	for _, entry := range header.Entries {
+		names = append(names, entry.Name)
+	}
+
+	name := entryNameForTest(t, root)
+	assert.Equal(t, []string{name + "/", name + "/dir/", name + "/dir/one.txt"}, names)
+	assert.Equal(t, []string{filepath.Join(root, "dangling"), filepath.Join(root, "linkdir")}, skipped)
+}
+
+func TestCreateStreamOverlappingPaths(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "src")
+	os.MkdirAll(root, 0755)
+	os.WriteFile(filepath.Join(root, "a.txt"), []byte("AAAAAAAA"), 0644)
+
+	outFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+	if err != nil {
+		t.Fatalf("Error creating output file: %v", err)
+	}
+	defer outFile.Close()
+
+	header, err := CreateStream(outFile, []string{root, filepath.Join(root, "a.txt")})
+	assert.Nil(t, err)
+
+	reader, err := NewReader(outFile)
+	assert.Nil(t, err)
+	assert.Equal(t, header, reader.Header)
+
+	name := entryNameForTest(t, root)
+	assert.Equal(t, 2, len(header.Entries))
+	assert.Equal(t, name+"/", header.Entries[0].Name)
+	assert.Equal(t, name+"/a.txt", header.Entries[1].Name)
+}
+
+func TestWriter(t *testing.T) {
+	newWriter := func(t *testing.T) *Writer {
+		outFile, err := os.Create(filepath.Join(t.TempDir(), "out.aarch"))
+		if err != nil {
+			t.Fatalf("Error creating output file: %v", err)
+		}
+		t.Cleanup(func() { outFile.Close() })
+
+		w, err := NewWriter(outFile, []*HeaderFileEntry{
+			NewHeaderFileEntry("dir/", 0),
+			NewHeaderFileEntry("dir/file.txt", 0),
+		})
+		if err != nil {
+			t.Fatalf("Error creating writer: %v", err)
+		}
+
+		return w
+	}
+
+	t.Run("unknown entry", func(t *testing.T) {
+		w := newWriter(t)
+		err := w.WriteFile("other.txt", strings.NewReader("AAAA"))
+
+		assert.ErrorIs(t, err, ErrUnknownEntry)
+	})
+
+	t.Run("entry already written", func(t *testing.T) {
+		w := newWriter(t)
+		assert.Nil(t, w.WriteFile("dir/file.txt", strings.NewReader("AAAA")))
+		err := w.WriteFile("dir/file.txt", strings.NewReader("AAAA"))
+
+		assert.ErrorIs(t, err, ErrEntryAlreadyWritten)
+	})
+
+	t.Run("missing entry data", func(t *testing.T) {
+		w := newWriter(t)
+		err := w.Close()
+
+		assert.ErrorIs(t, err, ErrMissingEntryData)
+	})
+
+	t.Run("directories don't need to be written", func(t *testing.T) {
+		w := newWriter(t)
+		assert.Nil(t, w.WriteFile("dir/file.txt", strings.NewReader("AAAA")))
+		assert.Nil(t, w.Close())
+
+		entries := w.Header().Entries
+		assert.Equal(t, uint64(0), entries[0].Size)
+		assert.Equal(t, NewChecksum([]byte("AAAA")), entries[1].Checksum)
+		assert.Equal(t, w.Header().HeaderLength+1, entries[1].Offset)
+	})
+}
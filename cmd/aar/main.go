@@ -3,30 +3,91 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math"
 	"os"
+	"strconv"
+	"strings"
 
+	"github.com/angelsolaorbaiceta/aar/archive"
 	"github.com/angelsolaorbaiceta/aar/cmd"
+	"github.com/dustin/go-humanize"
 )
 
 func main() {
 	var (
 		createCmd          = flag.NewFlagSet("create", flag.ExitOnError)
-		createFileNameFlag = createCmd.String("f", "", "Output filename of the archive")
+		createFileNameFlag = createCmd.String("f", "", "Output filename of the archive, or - for the standard output")
+		createNameFlag     = createCmd.String("name", "stdin", "Name of the file read from the standard input, given as -")
+		createCompressFlag = createCmd.String("compression", "xz", "Compression method: xz, store, deflate, gzip or zlib")
+		createDictFlag     = createCmd.String("dict-size", "", "Dictionary size for xz, like 64MiB, overriding the one of the level")
+		createEncryptFlag  = createCmd.Bool("encrypt", false, "Encrypt each file separately with a password, keeping the list of files readable")
+		createEncHeadFlag  = createCmd.Bool("encrypt-header", false, "Encrypt each file and the list of files with a password")
+		createLevelFlag    archive.CompressionLevel
+
+		addCmd          = flag.NewFlagSet("add", flag.ExitOnError)
+		addFileNameFlag = addCmd.String("f", "", "Filename of the archive to add the files to")
+
+		rmCmd          = flag.NewFlagSet("rm", flag.ExitOnError)
+		rmFileNameFlag = rmCmd.String("f", "", "Filename of the archive to remove the files from")
+
+		mvCmd          = flag.NewFlagSet("mv", flag.ExitOnError)
+		mvFileNameFlag = mvCmd.String("f", "", "Filename of the archive with the file to rename")
 
 		extractCmd          = flag.NewFlagSet("extract", flag.ExitOnError)
 		extractFileNameFlag = extractCmd.String("f", "", "Filename of the archive to extract")
 		extractNameFlag     = extractCmd.String("n", "", "Extract a specific file by name from the archive")
+		extractDirFlag      = extractCmd.String("C", ".", "Directory to extract the files into")
+		extractNoOwnerFlag  = extractCmd.Bool("no-same-owner", false, "Don't restore the files' owner")
+		extractNoPermsFlag  = extractCmd.Bool("no-same-permissions", false, "Don't restore the files' permissions")
+		extractNoMtimeFlag  = extractCmd.Bool("no-same-mtime", false, "Don't restore the files' modification time")
+		extractUnsafeFlag   = extractCmd.Bool("allow-unsafe-paths", false, "Extract files with absolute paths or .. components")
+		extractOverFlag     = extractCmd.String("overwrite", "refuse", "What to do with existing files: refuse, never, always, newer or prompt")
+		extractBackupFlag   = extractCmd.Bool("backup", false, "Rename the replaced files appending a ~ to their name")
+		extractStdoutFlag   = extractCmd.Bool("O", false, "Write the files to the standard output instead of to disk")
+		extractExcludeFlag  stringList
+
+		catCmd          = flag.NewFlagSet("cat", flag.ExitOnError)
+		catFileNameFlag = catCmd.String("f", "", "Filename of the archive to read the files from")
 
 		listCmd          = flag.NewFlagSet("list", flag.ExitOnError)
 		listFileNameFlag = listCmd.String("f", "", "Filename of the archive to list")
+		listFormatFlag   = listCmd.String("format", cmd.ListFormatShort, "Output format: short, long, json or csv")
+
+		infoCmd          = flag.NewFlagSet("info", flag.ExitOnError)
+		infoFileNameFlag = infoCmd.String("f", "", "Filename of the archive to describe")
+
+		verifyCmd          = flag.NewFlagSet("verify", flag.ExitOnError)
+		verifyFileNameFlag = verifyCmd.String("f", "", "Filename of the archive to verify")
 
 		encryptCmd          = flag.NewFlagSet("encrypt", flag.ExitOnError)
 		encryptFileNameFlag = encryptCmd.String("f", "", "Filename of the archive to encrypt")
+		encryptKDFFlag      = encryptCmd.String("kdf", "argon2id", "Key derivation function: argon2id or pbkdf2")
+		encryptIterFlag     = encryptCmd.Uint("kdf-iterations", 0, "Argon2id time cost or PBKDF2 iterations (default 3 or 600000)")
+		encryptMemoryFlag   = encryptCmd.String("kdf-memory", "", "Argon2id memory cost, like 64MiB (default 64MiB)")
+		encryptThreadsFlag  = encryptCmd.Uint("kdf-threads", 0, "Argon2id parallelism (default 4)")
+		encryptRecipFlag    stringList
 
 		decryptCmd          = flag.NewFlagSet("decrypt", flag.ExitOnError)
 		decryptFileNameFlag = decryptCmd.String("f", "", "Filename of the archive to decrypt")
+		decryptIdentityFlag stringList
+
+		passwdCmd          = flag.NewFlagSet("passwd", flag.ExitOnError)
+		passwdFileNameFlag = passwdCmd.String("f", "", "Filename of the encrypted archive to change the password of")
+
+		keygenCmd          = flag.NewFlagSet("keygen", flag.ExitOnError)
+		keygenFileNameFlag = keygenCmd.String("o", "", "Filename to write the key to, instead of the standard output")
 	)
 
+	for preset := 0; preset <= 9; preset++ {
+		name := strconv.Itoa(preset)
+		usage := fmt.Sprintf("Compress using preset %d (0 is the fastest, 9 the smallest; for xz, only the dictionary size changes)", preset)
+		createCmd.Var(presetFlag{level: &createLevelFlag, preset: preset}, name, usage)
+	}
+
+	encryptCmd.Var(&encryptRecipFlag, "r", "Encrypt to the public key, or file with public keys, instead of with a password (can be repeated)")
+	decryptCmd.Var(&decryptIdentityFlag, "i", "Decrypt with the identity file, instead of with a password (can be repeated)")
+	extractCmd.Var(&extractExcludeFlag, "exclude", "Don't extract the files matching the pattern (can be repeated)")
+
 	if len(os.Args) < 2 {
 		fmt.Fprintf(os.Stderr, "Usage: aar <command> [options]\n")
 		os.Exit(1)
@@ -37,37 +98,159 @@ func main() {
 		createCmd.Parse(os.Args[2:])
 		validateFileName(*createFileNameFlag)
 		fileNames := createCmd.Args()
-		createArchive(*createFileNameFlag, fileNames)
+		method, err := archive.ParseCompressionMethod(*createCompressFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%v. Use xz, store, deflate, gzip or zlib.\n", err)
+			os.Exit(1)
+		}
+
+		createOpts := archive.CreateOptions{
+			Compression:   method,
+			Level:         createLevelFlag,
+			DictSize:      parseDictSize(*createDictFlag),
+			EncryptHeader: *createEncHeadFlag,
+		}
+		if *createEncryptFlag || *createEncHeadFlag {
+			password := cmd.PromptPasswordWithConfirmation()
+			createOpts.Recipients = []archive.Recipient{archive.NewPasswordRecipient(password, archive.KDFParams{})}
+		}
+
+		createArchive(*createFileNameFlag, fileNames, *createNameFlag, createOpts)
+
+	case "add":
+		addCmd.Parse(os.Args[2:])
+		validateFileName(*addFileNameFlag)
+		fileNames := addCmd.Args()
+		if len(fileNames) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify at least one file to add to the archive.\n")
+			os.Exit(1)
+		}
+
+		cmd.AddToArchive(*addFileNameFlag, fileNames)
+
+	case "rm":
+		rmCmd.Parse(os.Args[2:])
+		validateFileName(*rmFileNameFlag)
+		names := rmCmd.Args()
+		if len(names) == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify at least one file to remove from the archive.\n")
+			os.Exit(1)
+		}
+
+		cmd.RemoveFromArchive(*rmFileNameFlag, names)
+
+	case "mv":
+		mvCmd.Parse(os.Args[2:])
+		validateFileName(*mvFileNameFlag)
+		if mvCmd.NArg() != 2 {
+			fmt.Fprintf(os.Stderr, "You must specify the file to rename and its new name.\n")
+			os.Exit(1)
+		}
+
+		cmd.RenameInArchive(*mvFileNameFlag, mvCmd.Arg(0), mvCmd.Arg(1))
 
 	case "extract":
 		extractCmd.Parse(os.Args[2:])
 		validateFileName(*extractFileNameFlag)
+		extractOpts := archive.ExtractOptions{
+			Restore: archive.RestoreOptions{
+				Owner:       !*extractNoOwnerFlag,
+				Permissions: !*extractNoPermsFlag,
+				ModTime:     !*extractNoMtimeFlag,
+			},
+			AllowUnsafePaths: *extractUnsafeFlag,
+			Overwrite:        parseOverwritePolicy(extractCmd, *extractOverFlag, *extractBackupFlag),
+		}
+		if *extractBackupFlag {
+			extractOpts.BackupSuffix = "~"
+		}
+
+		include := extractCmd.Args()
+		if *extractNameFlag != "" {
+			include = append(include, *extractNameFlag)
+		}
+
+		if *extractStdoutFlag {
+			if len(include) == 0 {
+				fmt.Fprintf(os.Stderr, "You must specify the files to write to the standard output.\n")
+				os.Exit(1)
+			}
 
-		if *extractNameFlag == "" {
-			cmd.ExtractArchive(*extractFileNameFlag)
-		} else {
-			cmd.ExtractArchiveFile(*extractFileNameFlag, *extractNameFlag)
+			cmd.CatArchive(*extractFileNameFlag, include, extractExcludeFlag)
+			return
 		}
 
+		cmd.ExtractArchive(*extractFileNameFlag, *extractDirFlag, include, extractExcludeFlag, extractOpts)
+
+	case "cat":
+		catCmd.Parse(os.Args[2:])
+		validateFileName(*catFileNameFlag)
+		if catCmd.NArg() == 0 {
+			fmt.Fprintf(os.Stderr, "You must specify at least one file to write to the standard output.\n")
+			os.Exit(1)
+		}
+
+		cmd.CatArchive(*catFileNameFlag, catCmd.Args(), nil)
+
 	case "list":
 		listCmd.Parse(os.Args[2:])
 		validateFileName(*listFileNameFlag)
-		cmd.ListArchive(*listFileNameFlag)
+		switch *listFormatFlag {
+		case cmd.ListFormatShort, cmd.ListFormatLong, cmd.ListFormatJSON, cmd.ListFormatCSV:
+		default:
+			fmt.Fprintf(os.Stderr, "Invalid format %q. Use short, long, json or csv.\n", *listFormatFlag)
+			os.Exit(1)
+		}
+
+		cmd.ListArchive(*listFileNameFlag, *listFormatFlag)
+
+	case "info":
+		infoCmd.Parse(os.Args[2:])
+		validateFileName(*infoFileNameFlag)
+		cmd.InfoArchive(*infoFileNameFlag)
+
+	case "verify":
+		verifyCmd.Parse(os.Args[2:])
+		validateFileName(*verifyFileNameFlag)
+		cmd.VerifyArchive(*verifyFileNameFlag)
 
 	case "encrypt":
 		encryptCmd.Parse(os.Args[2:])
 		validateFileName(*encryptFileNameFlag)
+		if len(encryptRecipFlag) > 0 {
+			cmd.EncryptArchiveTo(*encryptFileNameFlag, encryptRecipFlag)
+			return
+		}
+
+		encryptOpts := archive.EncryptOptions{
+			KDF: parseKDFParams(*encryptKDFFlag, *encryptIterFlag, *encryptMemoryFlag, *encryptThreadsFlag),
+		}
 		password := cmd.PromptPasswordWithConfirmation()
 
-		cmd.EncryptArchive(*encryptFileNameFlag, password)
+		cmd.EncryptArchive(*encryptFileNameFlag, password, encryptOpts)
 
 	case "decrypt":
 		decryptCmd.Parse(os.Args[2:])
 		validateFileName(*decryptFileNameFlag)
+		if len(decryptIdentityFlag) > 0 {
+			cmd.DecryptArchiveWith(*decryptFileNameFlag, decryptIdentityFlag)
+			return
+		}
+
 		password := cmd.PromptPassword()
 
 		cmd.DecryptArchive(*decryptFileNameFlag, password)
 
+	case "passwd":
+		passwdCmd.Parse(os.Args[2:])
+		validateFileName(*passwdFileNameFlag)
+		oldPassword, newPassword := cmd.PromptPasswordChange()
+		cmd.ChangeArchivePassword(*passwdFileNameFlag, oldPassword, newPassword)
+
+	case "keygen":
+		keygenCmd.Parse(os.Args[2:])
+		cmd.GenerateKey(*keygenFileNameFlag)
+
 	default:
 		fmt.Fprintf(os.Stderr, "Usage: aar <command> [options]\n")
 		os.Exit(1)
@@ -81,11 +264,129 @@ func validateFileName(name string) {
 	}
 }
 
-func createArchive(fileName string, fileNames []string) {
+func createArchive(fileName string, fileNames []string, stdinName string, opts archive.CreateOptions) {
 	if len(fileNames) == 0 {
 		fmt.Fprintf(os.Stderr, "You must specify at least one file to add to the archive.\n")
 		os.Exit(1)
 	}
 
-	cmd.CreateArchive(fileName, fileNames)
+	cmd.CreateArchive(fileName, fileNames, stdinName, opts)
+}
+
+// parseOverwritePolicy parses the --overwrite flag. With --backup, the existing files
+// are replaced unless another policy is set explicitly.
+func parseOverwritePolicy(flags *flag.FlagSet, name string, backup bool) archive.OverwritePolicy {
+	policy, err := archive.ParseOverwritePolicy(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v. Use refuse, never, always, newer or prompt.\n", err)
+		os.Exit(1)
+	}
+
+	overwriteSet := false
+	flags.Visit(func(f *flag.Flag) {
+		overwriteSet = overwriteSet || f.Name == "overwrite"
+	})
+
+	if backup && !overwriteSet {
+		return archive.OverwriteAlways
+	}
+
+	return policy
+}
+
+// parseDictSize parses the --dict-size flag, a size like 64MiB, or 0 if it's empty.
+func parseDictSize(value string) uint32 {
+	if value == "" {
+		return 0
+	}
+
+	size, err := humanize.ParseBytes(value)
+	if err != nil || size == 0 || size > math.MaxUint32 {
+		fmt.Fprintf(os.Stderr, "Invalid dictionary size %q. Use a size like 64MiB.\n", value)
+		os.Exit(1)
+	}
+
+	return uint32(size)
+}
+
+// parseKDFParams parses the key derivation flags of the encrypt command, using the
+// algorithm's defaults for the parameters that aren't set.
+func parseKDFParams(name string, iterations uint, memory string, threads uint) archive.KDFParams {
+	algorithm, err := archive.ParseKDFAlgorithm(name)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v. Use argon2id or pbkdf2.\n", err)
+		os.Exit(1)
+	}
+
+	params := archive.DefaultKDFParams()
+	if algorithm == archive.KDFPBKDF2 {
+		params = archive.KDFParams{Algorithm: algorithm, Iterations: archive.DefaultPBKDF2Iterations}
+	}
+
+	if iterations > math.MaxUint32 || threads > math.MaxUint8 {
+		fmt.Fprintf(os.Stderr, "Invalid key derivation parameters.\n")
+		os.Exit(1)
+	}
+	if iterations != 0 {
+		params.Iterations = uint32(iterations)
+	}
+	if threads != 0 {
+		params.Threads = uint8(threads)
+	}
+	if memory != "" {
+		size, err := humanize.ParseBytes(memory)
+		if err != nil || size/1024 > math.MaxUint32 {
+			fmt.Fprintf(os.Stderr, "Invalid memory cost %q. Use a size like 64MiB.\n", memory)
+			os.Exit(1)
+		}
+
+		params.Memory = uint32(size / 1024)
+	}
+
+	if err := params.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "%v.\n", err)
+		os.Exit(1)
+	}
+
+	return params
+}
+
+// presetFlag is a boolean flag, like -9, that sets the compression level to its preset.
+type presetFlag struct {
+	level  *archive.CompressionLevel
+	preset int
+}
+
+func (f presetFlag) String() string {
+	return "false"
+}
+
+func (f presetFlag) IsBoolFlag() bool {
+	return true
+}
+
+func (f presetFlag) Set(value string) error {
+	if set, err := strconv.ParseBool(value); err != nil || !set {
+		return err
+	}
+
+	level, err := archive.Preset(f.preset)
+	if err != nil {
+		return err
+	}
+
+	*f.level = level
+	return nil
+}
+
+// stringList is a flag that can be repeated, collecting all its values.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
 }
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+	"github.com/dustin/go-humanize"
+)
+
+// AddToArchive adds the files in the provided paths to the archive, walking directories
+// recursively. The existing files are copied without recompressing them.
+func AddToArchive(fileName string, inFileNames []string) {
+	fmt.Fprintf(os.Stderr, "Adding %d files to archive %s...\n", len(inFileNames), fileName)
+
+	var oldCount int
+	header := rewriteArchive(fileName, func(ws io.WriteSeeker, r *archive.Reader) (*archive.Header, error) {
+		oldCount = len(r.Header.Entries)
+		return archive.Append(ws, r, inFileNames, warnSkipped)
+	})
+
+	fmt.Fprintf(os.Stderr, "Files added successfully.\n")
+	fmt.Fprintf(os.Stderr, "	> Archive size = %s.\n", humanize.Bytes(header.TotalSize()))
+	fmt.Fprintf(os.Stderr, "Files added to archive:\n")
+	for _, entry := range header.Entries[oldCount:] {
+		size := humanize.Bytes(entry.Size)
+		fmt.Fprintf(os.Stderr, "	> %s (compressed size = %s)\n", entry.Name, size)
+	}
+}
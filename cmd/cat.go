@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// CatArchive writes the decompressed data of the files matching the patterns, but none
+// of the exclude ones, to the standard output, in the order the patterns are given.
+// Matched directories write the files inside them. The progress and diagnostics go to
+// the standard error, so that the output can be piped into other programs.
+func CatArchive(fileName string, patterns, exclude []string) {
+	reader, closeArchive, err := openArchive(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeArchive()
+
+	if err := unlockArchive(reader); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Select all the files up front, so that nothing is written if any is missing
+	selected := make([][]*archive.HeaderFileEntry, len(patterns))
+	for i, pattern := range patterns {
+		selected[i], err = reader.Header.Select([]string{pattern}, exclude)
+		if errors.Is(err, archive.ErrEntryNotFoundInHeader) {
+			fmt.Fprintf(os.Stderr, "File not found in archive: %s\n", pattern)
+			fmt.Fprintf(os.Stderr, "Use the list command to see the files in the archive.\n")
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error selecting files: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	for _, entries := range selected {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				fmt.Fprintf(os.Stderr, "Writing %s...\n", entry.Name)
+			}
+
+			if err := catEntry(out, reader, entry); err != nil {
+				out.Flush()
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", entry.Name, err)
+				os.Exit(1)
+			}
+		}
+	}
+
+	if err := out.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing output: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// catEntry streams the decompressed entry into w. Directories have no data.
+func catEntry(w io.Writer, reader *archive.Reader, entry *archive.HeaderFileEntry) error {
+	data, err := reader.OpenEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, err = io.Copy(w, data)
+	return err
+}
@@ -2,21 +2,75 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"time"
 
 	"github.com/angelsolaorbaiceta/aar/archive"
 	"github.com/dustin/go-humanize"
+	"golang.org/x/term"
 )
 
-func CreateArchive(outFileName string, inFileNames []string) {
+// CreateArchive creates the archive with the files in the provided paths. The archive
+// is written to the standard output if its name is StdioName, and a StdioName path
+// adds the standard input as a file named stdinName. The files are written using the
+// options.
+func CreateArchive(outFileName string, inFileNames []string, stdinName string, opts archive.CreateOptions) {
 	fmt.Fprintf(os.Stderr, "Creating archive %s with %d files...\n", outFileName, len(inFileNames))
 
-	archive, err := archive.Create(inFileNames)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating archive: %v\n", err)
-		os.Exit(1)
+	var (
+		paths   []string
+		readers []*archive.NamedReader
+	)
+	for _, name := range inFileNames {
+		if name != StdioName {
+			paths = append(paths, name)
+			continue
+		}
+
+		if len(readers) > 0 {
+			fmt.Fprintf(os.Stderr, "The standard input can only be added once.\n")
+			os.Exit(1)
+		}
+
+		readers = append(readers, &archive.NamedReader{
+			Name:     stdinName,
+			Metadata: archive.Metadata{Mode: 0644, ModTime: time.Now()},
+			Reader:   os.Stdin,
+		})
 	}
+	opts.OnSkip = warnSkipped
 
+	var header *archive.Header
+	if outFileName == StdioName {
+		header = createToStdout(paths, readers, opts)
+	} else {
+		header = createToFile(outFileName, paths, readers, opts)
+	}
+
+	var (
+		archSize   = humanize.Bytes(header.TotalSize())
+		headerSize = humanize.Bytes(header.HeaderLength)
+	)
+
+	fmt.Fprintf(os.Stderr, "Archive created successfully.\n")
+	fmt.Fprintf(os.Stderr, "	> Archive size = %s.\n", archSize)
+	fmt.Fprintf(os.Stderr, "	> Header size = %s.\n", headerSize)
+	fmt.Fprintf(os.Stderr, "Files in archive:\n")
+	for _, entry := range header.Entries {
+		size := humanize.Bytes(entry.Size)
+		fmt.Fprintf(os.Stderr, "	> %s (compressed size = %s)\n", entry.Name, size)
+	}
+}
+
+// warnSkipped warns that the file at path isn't archived, as it's a symbolic link or
+// another file that isn't a regular file or directory.
+func warnSkipped(path string) {
+	fmt.Fprintf(os.Stderr, "Skipping %s: not a regular file or directory.\n", path)
+}
+
+// createToFile creates the archive file, removing it if it can't be completed.
+func createToFile(outFileName string, paths []string, readers []*archive.NamedReader, opts archive.CreateOptions) *archive.Header {
 	outFile, err := os.Create(outFileName)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error creating output file: %v\n", err)
@@ -24,23 +78,52 @@ func CreateArchive(outFileName string, inFileNames []string) {
 	}
 	defer outFile.Close()
 
-	err = archive.Write(outFile)
+	// Files are compressed straight into the output file, one at a time, so that
+	// memory usage doesn't depend on their size
+	header, err := archive.CreateStreamFrom(outFile, paths, readers, opts)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+		outFile.Close()
+		os.Remove(outFileName)
+
+		fmt.Fprintf(os.Stderr, "Error creating archive: %v\n", err)
 		os.Exit(1)
 	}
 
-	var (
-		archSize   = humanize.Bytes(uint64(archive.TotalSize()))
-		headerSize = humanize.Bytes(uint64(archive.Header.HeaderLength))
-	)
+	return header
+}
 
-	fmt.Fprintf(os.Stderr, "Archive created successfully.\n")
-	fmt.Fprintf(os.Stderr, "	> Archive size = %s.\n", archSize)
-	fmt.Fprintf(os.Stderr, "	> Header size = %s.\n", headerSize)
-	fmt.Fprintf(os.Stderr, "Files in archive:\n")
-	for _, file := range archive.Files {
-		size := humanize.Bytes(uint64(file.CompressedSize()))
-		fmt.Fprintf(os.Stderr, "	> %s (compressed size = %s)\n", file.FileName, size)
+// createToStdout writes the archive to the standard output. As the header, which goes
+// first, is only complete once all the files are compressed, and the standard output
+// can't be seeked back, the archive is written to a temporary file first.
+func createToStdout(paths []string, readers []*archive.NamedReader, opts archive.CreateOptions) *archive.Header {
+	if term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Fprintf(os.Stderr, "Refusing to write the archive to a terminal.\n")
+		os.Exit(1)
 	}
+
+	tmpFile, err := os.CreateTemp("", "aar-stdout-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating temporary file: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	header, err := archive.CreateStreamFrom(tmpFile, paths, readers, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	if _, err := io.Copy(os.Stdout, tmpFile); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	return header
 }
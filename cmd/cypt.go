@@ -2,99 +2,168 @@ package cmd
 
 import (
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/angelsolaorbaiceta/aar/archive"
 )
 
-func EncryptArchive(fileName, password string) {
-	// Read the archive
+// EncryptArchive encrypts the archive with the password, using the options, replacing
+// it with the encrypted archive, named after it with the .enc extension. The archive
+// is streamed through the encryption, so that it takes constant memory.
+func EncryptArchive(fileName, password string, opts archive.EncryptOptions) {
+	encryptArchive(fileName, func(w io.Writer) (io.WriteCloser, error) {
+		return archive.NewEncryptWriter(w, password, opts)
+	})
+}
+
+// EncryptArchiveTo is like EncryptArchive, but it encrypts the archive to the recipients,
+// each of them a public key or a file with public keys, instead of with a password.
+func EncryptArchiveTo(fileName string, recipientArgs []string) {
+	recipients, err := parseRecipients(recipientArgs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading recipients: %v\n", err)
+		os.Exit(1)
+	}
+
+	encryptArchive(fileName, func(w io.Writer) (io.WriteCloser, error) {
+		return archive.EncryptTo(w, recipients...)
+	})
+}
+
+// encryptArchive encrypts the archive with the writer returned by newEncryptWriter,
+// replacing it with the encrypted archive.
+func encryptArchive(fileName string, newEncryptWriter func(w io.Writer) (io.WriteCloser, error)) {
+	// Open the archive, checking that it's an archive
 	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error opening archive file: %v\n", err)
 		os.Exit(1)
 	}
+	defer reader.Close()
 
-	arch, err := archive.ReadArchive(reader)
-	if err != nil {
+	if _, err := archive.ReadHeader(reader); err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Encrypt the archive
-	encArch, err := arch.Encrypt(password)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error encrypting archive: %v\n", err)
+	if _, err := reader.Seek(0, io.SeekStart); err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Write the encrypted archive to disk
 	encFileName := fileName + ".enc"
-	encFile, err := os.Create(encFileName)
+	err = writeFile(encFileName, func(w io.Writer) error {
+		encWriter, err := newEncryptWriter(w)
+		if err != nil {
+			return err
+		}
+
+		if _, err := io.Copy(encWriter, reader); err != nil {
+			return err
+		}
+
+		return encWriter.Close()
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating encrypted archive file: %v\n", err)
-		os.Exit(1)
-	}
-	defer encFile.Close()
-
-	if err := encArch.Write(encFile); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing encrypted archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error encrypting archive: %v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Fprintf(os.Stderr, "Archive encrypted successfully to %s\n", encFileName)
 
 	// Remove the original archive
+	reader.Close()
 	if err := os.Remove(fileName); err != nil {
 		fmt.Fprintf(os.Stderr, "Error removing original archive: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// DecryptArchive decrypts the encrypted archive with the password, replacing it with the
+// decrypted archive. The archive is streamed through the decryption, so that it takes
+// constant memory, and the encrypted archive is only removed once all of it has been
+// authenticated.
 func DecryptArchive(fileName, password string) {
-	// Read the encrypted archive
-	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+	decryptArchive(fileName, func(r io.Reader) (io.Reader, error) {
+		return archive.NewDecryptReader(r, password)
+	})
+}
+
+// DecryptArchiveWith is like DecryptArchive, but it decrypts the archive with the
+// identities in the named files, instead of with a password.
+func DecryptArchiveWith(fileName string, identityFileNames []string) {
+	identities, err := parseIdentities(identityFileNames)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening encrypted archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading identities: %v\n", err)
 		os.Exit(1)
 	}
 
-	encArch, err := archive.ReadEncryptedArchive(reader)
+	decryptArchive(fileName, func(r io.Reader) (io.Reader, error) {
+		return archive.DecryptWith(r, identities...)
+	})
+}
+
+// decryptArchive decrypts the encrypted archive with the reader returned by
+// newDecryptReader, replacing it with the decrypted archive.
+func decryptArchive(fileName string, newDecryptReader func(r io.Reader) (io.Reader, error)) {
+	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading encrypted archive: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error opening encrypted archive file: %v\n", err)
 		os.Exit(1)
 	}
+	defer reader.Close()
 
-	// Decrypt the archive
-	arch, err := encArch.Decrypt(password)
+	decReader, err := newDecryptReader(reader)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading encrypted archive: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Write the decrypted archive to disk
 	decFileName := decryptFileName(fileName)
-	decFile, err := os.Create(decFileName)
+	err = writeFile(decFileName, func(w io.Writer) error {
+		_, err := io.Copy(w, decReader)
+		return err
+	})
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating decrypted archive file: %v\n", err)
-		os.Exit(1)
-	}
-	defer decFile.Close()
-
-	if err := arch.Write(decFile); err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing decrypted archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
 		os.Exit(1)
 	}
 
 	fmt.Fprintf(os.Stderr, "Archive decrypted successfully to %s\n", decFileName)
 
 	// Remove the encrypted archive
+	reader.Close()
 	if err := os.Remove(fileName); err != nil {
 		fmt.Fprintf(os.Stderr, "Error removing encrypted archive: %v\n", err)
 		os.Exit(1)
 	}
 }
 
+// writeFile creates the named file and writes it with write, removing it if it fails.
+func writeFile(fileName string, write func(w io.Writer) error) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
+	}
+
+	if err := write(file); err != nil {
+		file.Close()
+		os.Remove(fileName)
+		return err
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(fileName)
+		return err
+	}
+
+	return file.Close()
+}
+
 // decryptFileName returns the decrypted file name from the encrypted file name.
 // If the file name doesn't end with ".enc", it appends ".dec" to the file name.
 // Otherwise, it removes the ".enc" extension.
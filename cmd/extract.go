@@ -1,72 +1,84 @@
 package cmd
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/angelsolaorbaiceta/aar/archive"
 )
 
-func ExtractArchive(fileName string) {
-	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+// ExtractArchive extracts the files in the archive matching any of the include
+// patterns, but none of the exclude ones, into the destination directory. With no
+// include patterns, all the files are extracted.
+func ExtractArchive(fileName, destDir string, include, exclude []string, opts archive.ExtractOptions) {
+	reader, closeArchive, err := openArchive(fileName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
 		os.Exit(1)
 	}
+	defer closeArchive()
 
-	arch, err := archive.ReadArchive(reader)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
+	if err := unlockArchive(reader); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
 		os.Exit(1)
 	}
 
-	for _, file := range arch.Files {
-		fmt.Fprintf(os.Stderr, "Extracting %s...\n", file.FileName)
-		outFile, err := os.Create(file.FileName)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
-			os.Exit(1)
-		}
-		defer outFile.Close()
-
-		err = file.WriteDecompressed(outFile)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
-			os.Exit(1)
-		}
+	entries, err := reader.Header.Select(include, exclude)
+	if errors.Is(err, archive.ErrEntryNotFoundInHeader) {
+		fmt.Fprintf(os.Stderr, "Files not found in archive:\n%v\n", err)
+		fmt.Fprintf(os.Stderr, "Use the list command to see the files in the archive.\n")
+		os.Exit(1)
 	}
-}
-
-func ExtractArchiveFile(fileName, fileToExtract string) {
-	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error selecting files: %v\n", err)
 		os.Exit(1)
 	}
-	defer reader.Close()
 
-	archFile, err := archive.ReadFileByName(reader, fileToExtract)
-	if err != nil {
-		if err == archive.ErrEntryNotFoundInHeader {
-			fmt.Fprintf(os.Stderr, "File not found in archive: %s\n", fileToExtract)
-			fmt.Fprintf(os.Stderr, "Use the list command to see the files in the archive.\n")
-		} else {
-			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
-		}
+	extract(reader, destDir, entries, opts)
+}
 
-		os.Exit(1)
+// extract extracts the entries into the destination directory, reporting each of them.
+func extract(reader *archive.Reader, destDir string, entries []*archive.HeaderFileEntry, opts archive.ExtractOptions) {
+	opts.OnExtract = func(entry *archive.HeaderFileEntry) {
+		fmt.Fprintf(os.Stderr, "Extracting %s...\n", entry.Name)
+	}
+	if opts.Overwrite == archive.OverwritePrompt {
+		opts.ConfirmOverwrite = confirmOverwrite
 	}
 
-	outFile, err := os.Create(archFile.FileName)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error creating file: %v\n", err)
+	err := reader.Extract(destDir, entries, opts)
+	if errors.Is(err, archive.ErrUnsafePath) {
+		fmt.Fprintf(os.Stderr, "Refusing to extract unsafe files:\n%v\n", err)
+		fmt.Fprintf(os.Stderr, "Use --allow-unsafe-paths to extract them anyway.\n")
+		os.Exit(1)
+	}
+	if errors.Is(err, archive.ErrFileExists) {
+		fmt.Fprintf(os.Stderr, "Refusing to overwrite existing files:\n%v\n", err)
+		fmt.Fprintf(os.Stderr, "Use --overwrite or --backup to replace them.\n")
 		os.Exit(1)
 	}
-	defer outFile.Close()
-
-	err = archFile.WriteDecompressed(outFile)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error writing file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error extracting archive: %v\n", err)
 		os.Exit(1)
 	}
 }
+
+// stdin buffers the user's answers, shared by all the prompts so that no input is lost.
+var stdin = bufio.NewReader(os.Stdin)
+
+// confirmOverwrite asks the user whether to overwrite the existing file at path.
+func confirmOverwrite(entry *archive.HeaderFileEntry, path string) bool {
+	fmt.Fprintf(os.Stderr, "Overwrite %s? [y/N] ", path)
+
+	answer, err := stdin.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Fprintln(os.Stderr)
+		return false
+	}
+
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+	"github.com/dustin/go-humanize"
+)
+
+// InfoArchive prints a summary of the archive: its format, sizes and the compression
+// used for its files.
+func InfoArchive(fileName string) {
+	reader, closeArchive, err := openArchive(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeArchive()
+
+	var (
+		header       = reader.Header
+		files, dirs  int
+		uncompressed uint64
+		compression  []string
+		counts       = make(map[string]int)
+	)
+
+	for _, entry := range header.Entries {
+		if entry.IsDir() {
+			dirs++
+			continue
+		}
+
+		size, err := reader.UncompressedSize(entry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", entry.Name, err)
+			os.Exit(1)
+		}
+
+		files++
+		uncompressed += size
+
+		used := describeCompression(header, entry)
+		if counts[used] == 0 {
+			compression = append(compression, used)
+		}
+		counts[used]++
+	}
+
+	compressed := header.TotalSize() - header.HeaderLength
+
+	fmt.Fprintf(os.Stdout, "Format:         %s\n", header.Version)
+	fmt.Fprintf(os.Stdout, "Flags:          %#08x\n", uint32(header.Flags))
+	fmt.Fprintf(os.Stdout, "Encryption:     %s\n", describeEncryption(header))
+	fmt.Fprintf(os.Stdout, "Archive size:   %s\n", humanize.Bytes(header.TotalSize()))
+	fmt.Fprintf(os.Stdout, "Header size:    %s\n", humanize.Bytes(header.HeaderLength))
+	fmt.Fprintf(os.Stdout, "Files:          %d (and %d directories)\n", files, dirs)
+	fmt.Fprintf(os.Stdout, "Compressed:     %s\n", humanize.Bytes(compressed))
+	fmt.Fprintf(os.Stdout, "Uncompressed:   %s\n", humanize.Bytes(uncompressed))
+	if uncompressed > 0 {
+		fmt.Fprintf(os.Stdout, "Ratio:          %.2f\n", float64(compressed)/float64(uncompressed))
+	}
+
+	fmt.Fprintf(os.Stdout, "Compression:\n")
+	for _, used := range compression {
+		fmt.Fprintf(os.Stdout, "	> %s: %d files\n", used, counts[used])
+	}
+}
+
+// describeEncryption describes what's encrypted in the archive.
+func describeEncryption(header *archive.Header) string {
+	switch {
+	case header.Flags&archive.FlagEncryptedHeader != 0:
+		return "files and header"
+	case header.Flags&archive.FlagEncryptedEntries != 0:
+		return "files"
+	default:
+		return "none"
+	}
+}
+
+// describeCompression describes the compression method and options used for the entry,
+// as far as the archive stores them.
+func describeCompression(header *archive.Header, entry *archive.HeaderFileEntry) string {
+	parts := []string{entry.Method.String()}
+
+	if header.Flags&archive.FlagCompressionOptions == 0 {
+		return parts[0]
+	}
+
+	parts = append(parts, "level "+entry.Level.String())
+	if entry.DictSize != 0 {
+		parts = append(parts, humanize.IBytes(uint64(entry.DictSize))+" dictionary")
+	}
+
+	return strings.Join(parts, ", ")
+}
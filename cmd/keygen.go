@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// GenerateKey generates a new X25519 identity and writes it to the named file, or to
+// the standard output if the name is empty, printing its public key. Existing files
+// aren't overwritten, and the file is only readable by its owner.
+func GenerateKey(outFileName string) {
+	identity, err := archive.GenerateX25519Identity()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error generating key: %v\n", err)
+		os.Exit(1)
+	}
+
+	publicKey := identity.Recipient().String()
+	contents := fmt.Sprintf(
+		"# created: %s\n# public key: %s\n%s\n",
+		time.Now().Format(time.RFC3339), publicKey, identity,
+	)
+
+	if outFileName == "" {
+		fmt.Fprint(os.Stdout, contents)
+	} else {
+		file, err := os.OpenFile(outFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error creating key file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		if _, err := file.WriteString(contents); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing key file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "Public key: %s\n", publicKey)
+}
+
+// parseRecipients parses the recipients given on the command line. Each of them is a
+// public key, or the name of a file with public keys, one per line.
+func parseRecipients(args []string) ([]archive.Recipient, error) {
+	var recipients []archive.Recipient
+
+	for _, arg := range args {
+		reader := strings.NewReader(arg)
+		if _, err := archive.ParseX25519Recipient(arg); err != nil {
+			data, readErr := os.ReadFile(arg)
+			if readErr != nil {
+				return nil, err
+			}
+
+			reader = strings.NewReader(string(data))
+		}
+
+		parsed, err := archive.ParseRecipients(reader)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", arg, err)
+		}
+
+		recipients = append(recipients, parsed...)
+	}
+
+	return recipients, nil
+}
+
+// parseIdentities parses the identities in the named files.
+func parseIdentities(fileNames []string) ([]archive.Identity, error) {
+	var identities []archive.Identity
+
+	for _, fileName := range fileNames {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := archive.ParseIdentities(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", fileName, err)
+		}
+
+		identities = append(identities, parsed...)
+	}
+
+	return identities, nil
+}
@@ -1,21 +1,106 @@
 package cmd
 
 import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
 
 	"github.com/angelsolaorbaiceta/aar/archive"
+	"github.com/dustin/go-humanize"
 )
 
-func ListArchive(fileName string) {
-	reader, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+// The formats of the list command.
+const (
+	ListFormatShort = "short"
+	ListFormatLong  = "long"
+	ListFormatJSON  = "json"
+	ListFormatCSV   = "csv"
+)
+
+// listEntry is an archive entry as listed by the long, JSON and CSV formats.
+// The metadata fields are empty when the archive doesn't store them.
+type listEntry struct {
+	Name             string     `json:"name"`
+	Type             string     `json:"type"`
+	Offset           uint64     `json:"offset"`
+	CompressedSize   uint64     `json:"compressed_size"`
+	UncompressedSize uint64     `json:"uncompressed_size"`
+	Ratio            float64    `json:"ratio"`
+	Compression      string     `json:"compression"`
+	Mode             string     `json:"mode,omitempty"`
+	ModTime          *time.Time `json:"mtime,omitempty"`
+	UID              *uint32    `json:"uid,omitempty"`
+	GID              *uint32    `json:"gid,omitempty"`
+	Checksum         string     `json:"checksum,omitempty"`
+}
+
+// ListArchive lists the files in the archive using the given format. The short format
+// only reads the header, while the others also need the files' uncompressed sizes,
+// decompressing them in archives that don't store them.
+func ListArchive(fileName, format string) {
+	if format == ListFormatShort {
+		listShort(fileName)
+		return
+	}
+
+	reader, closeArchive, err := openArchive(fileName)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error opening archive file: %v\n", err)
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
 		os.Exit(1)
 	}
-	defer reader.Close()
+	defer closeArchive()
+
+	entries := make([]*listEntry, len(reader.Header.Entries))
+	for i, entry := range reader.Header.Entries {
+		if entries[i], err = newListEntry(reader, entry); err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", entry.Name, err)
+			os.Exit(1)
+		}
+	}
+
+	switch format {
+	case ListFormatLong:
+		err = listLong(os.Stdout, entries)
+	case ListFormatJSON:
+		err = listJSON(os.Stdout, entries)
+	case ListFormatCSV:
+		err = listCSV(os.Stdout, entries)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing list: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// listShort prints the entries as described by their String method.
+func listShort(fileName string) {
+	// Only the header is read, so the standard input can be read as it comes
+	reader := os.Stdin
+	if fileName != StdioName {
+		file, err := os.OpenFile(fileName, os.O_RDONLY, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening archive file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+
+		reader = file
+	}
 
 	header, err := archive.ReadHeader(reader)
+	if errors.Is(err, archive.ErrEncrypted) {
+		// The header is read again with the password, unless it comes through a pipe
+		if _, seekErr := reader.Seek(0, io.SeekStart); seekErr == nil {
+			header, err = archive.ReadHeader(reader, archive.NewPasswordIdentity(PromptPassword()))
+		}
+	}
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading archive header: %v\n", err)
 		os.Exit(1)
@@ -26,3 +111,124 @@ func ListArchive(fileName string) {
 		fmt.Fprintf(os.Stdout, "	> %s\n", entry)
 	}
 }
+
+// newListEntry creates the listing of the entry.
+func newListEntry(reader *archive.Reader, entry *archive.HeaderFileEntry) (*listEntry, error) {
+	size, err := reader.UncompressedSize(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	listed := &listEntry{
+		Name:             entry.Name,
+		Type:             "file",
+		Offset:           entry.Offset,
+		CompressedSize:   entry.Size,
+		UncompressedSize: size,
+		Compression:      entry.Method.String(),
+	}
+
+	if entry.IsDir() {
+		listed.Type = "dir"
+	}
+
+	if size > 0 {
+		listed.Ratio = float64(entry.Size) / float64(size)
+	}
+
+	if reader.Header.Flags&archive.FlagMetadata != 0 {
+		listed.Mode = fmt.Sprintf("%04o", entry.Mode.Perm())
+		listed.UID = &entry.UID
+		listed.GID = &entry.GID
+
+		if !entry.ModTime.IsZero() {
+			modTime := entry.ModTime.UTC()
+			listed.ModTime = &modTime
+		}
+	}
+
+	if !entry.Checksum.IsZero() {
+		listed.Checksum = entry.Checksum.String()
+	}
+
+	return listed, nil
+}
+
+// listLong writes the entries as a table, like ls -l.
+func listLong(w io.Writer, entries []*listEntry) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+
+	fmt.Fprintf(tw, "MODE\tOWNER\tOFFSET\tCOMPRESSED\tSIZE\tRATIO\tMETHOD\tMODIFIED\t NAME\n")
+	for _, entry := range entries {
+		var (
+			mode    = "-"
+			owner   = "-"
+			modTime = "-"
+		)
+
+		if entry.Mode != "" {
+			mode = entry.Mode
+			owner = fmt.Sprintf("%d:%d", *entry.UID, *entry.GID)
+		}
+
+		if entry.ModTime != nil {
+			modTime = entry.ModTime.Local().Format(time.DateTime)
+		}
+
+		fmt.Fprintf(
+			tw, "%s\t%s\t%d\t%s\t%s\t%.2f\t%s\t%s\t %s\n",
+			mode, owner, entry.Offset,
+			humanize.Bytes(entry.CompressedSize), humanize.Bytes(entry.UncompressedSize),
+			entry.Ratio, entry.Compression, modTime, entry.Name,
+		)
+	}
+
+	return tw.Flush()
+}
+
+// listJSON writes the entries as a JSON array.
+func listJSON(w io.Writer, entries []*listEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(entries)
+}
+
+// listCSV writes the entries as CSV, with a header row.
+func listCSV(w io.Writer, entries []*listEntry) error {
+	csvWriter := csv.NewWriter(w)
+
+	csvWriter.Write([]string{
+		"name", "type", "offset", "compressed_size", "uncompressed_size", "ratio",
+		"compression", "mode", "mtime", "uid", "gid", "checksum",
+	})
+
+	for _, entry := range entries {
+		var modTime, uid, gid string
+		if entry.ModTime != nil {
+			modTime = entry.ModTime.Format(time.RFC3339Nano)
+		}
+		if entry.UID != nil {
+			uid = strconv.FormatUint(uint64(*entry.UID), 10)
+			gid = strconv.FormatUint(uint64(*entry.GID), 10)
+		}
+
+		csvWriter.Write([]string{
+			entry.Name,
+			entry.Type,
+			strconv.FormatUint(entry.Offset, 10),
+			strconv.FormatUint(entry.CompressedSize, 10),
+			strconv.FormatUint(entry.UncompressedSize, 10),
+			strconv.FormatFloat(entry.Ratio, 'f', 4, 64),
+			entry.Compression,
+			entry.Mode,
+			modTime,
+			uid,
+			gid,
+			entry.Checksum,
+		})
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
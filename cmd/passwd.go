@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// ChangeArchivePassword changes the password of the encrypted archive, rewriting only
+// the data key wrapped with the password, so that the archive isn't decrypted to disk.
+// The archive is copied to a temporary file that replaces it once written.
+func ChangeArchivePassword(fileName, oldPassword, newPassword string) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening encrypted archive file: %v\n", err)
+		os.Exit(1)
+	}
+	defer file.Close()
+
+	err = replaceFile(fileName, func(f *os.File) error {
+		return archive.ChangePassword(f, file, oldPassword, newPassword)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error changing the password: %v\n", err)
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "Password of %s changed successfully\n", fileName)
+}
@@ -3,36 +3,32 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"syscall"
 
 	"golang.org/x/term"
 )
 
 func PromptPasswordWithConfirmation() string {
-	fmt.Print("Password: ")
-	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-		os.Exit(1)
-	}
+	return promptNewPassword("Password: ", "Confirm password: ")
+}
 
-	// Move to the next line after password input
-	fmt.Println()
+func PromptPassword() string {
+	return promptPassword("Password: ")
+}
 
-	password := string(passwordBytes)
-	validatePassword(password)
+// PromptPasswordChange prompts for the current password of an archive, and for the new
+// one with confirmation.
+func PromptPasswordChange() (oldPassword, newPassword string) {
+	oldPassword = promptPassword("Current password: ")
+	newPassword = promptNewPassword("New password: ", "Confirm new password: ")
 
-	fmt.Print("Confirm password: ")
-	passwordConfirmationBytes, err := term.ReadPassword(int(syscall.Stdin))
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
-		os.Exit(1)
-	}
+	return oldPassword, newPassword
+}
 
-	// Move to the next line after password input
-	fmt.Println()
+func promptNewPassword(prompt, confirmationPrompt string) string {
+	password := promptPassword(prompt)
+	validatePassword(password)
 
-	passwordConfirmation := string(passwordConfirmationBytes)
+	passwordConfirmation := promptPassword(confirmationPrompt)
 	if password != passwordConfirmation {
 		fmt.Fprintf(os.Stderr, "Passwords do not match.\n")
 		os.Exit(1)
@@ -41,16 +37,26 @@ func PromptPasswordWithConfirmation() string {
 	return password
 }
 
-func PromptPassword() string {
-	fmt.Print("Password: ")
-	passwordBytes, err := term.ReadPassword(int(syscall.Stdin))
+// promptPassword prompts for a password on the standard error, so that it doesn't mix
+// with the archives and files written to the standard output. The password is read from
+// the terminal rather than the standard input, which may be the archive itself.
+func promptPassword(prompt string) string {
+	tty, err := os.OpenFile(ttyName, os.O_RDWR, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading password: no terminal to prompt for it: %v\n", err)
+		os.Exit(1)
+	}
+	defer tty.Close()
+
+	fmt.Fprint(os.Stderr, prompt)
+	passwordBytes, err := term.ReadPassword(int(tty.Fd()))
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error reading password: %v\n", err)
 		os.Exit(1)
 	}
 
 	// Move to the next line after password input
-	fmt.Println()
+	fmt.Fprintln(os.Stderr)
 
 	return string(passwordBytes)
 }
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+	"github.com/dustin/go-humanize"
+)
+
+// RemoveFromArchive removes the named files from the archive, including the contents
+// of the named directories, compacting the remaining files' data.
+func RemoveFromArchive(fileName string, names []string) {
+	fmt.Fprintf(os.Stderr, "Removing %d files from archive %s...\n", len(names), fileName)
+
+	header := rewriteArchive(fileName, func(ws io.WriteSeeker, r *archive.Reader) (*archive.Header, error) {
+		return archive.Remove(ws, r, names)
+	})
+
+	fmt.Fprintf(os.Stderr, "Files removed successfully.\n")
+	fmt.Fprintf(os.Stderr, "	> Archive size = %s.\n", humanize.Bytes(header.TotalSize()))
+	fmt.Fprintf(os.Stderr, "	> Files left in the archive = %d.\n", len(header.Entries))
+}
+
+// RenameInArchive renames the oldName file, or directory with all its contents, to newName.
+func RenameInArchive(fileName, oldName, newName string) {
+	rewriteArchive(fileName, func(ws io.WriteSeeker, r *archive.Reader) (*archive.Header, error) {
+		return archive.Rename(ws, r, oldName, newName)
+	})
+
+	fmt.Fprintf(os.Stderr, "Renamed %s to %s in archive %s.\n", oldName, newName, fileName)
+}
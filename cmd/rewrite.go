@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// rewriteFunc writes a modified copy of the archive read by r into ws, returning the
+// copy's header.
+type rewriteFunc func(ws io.WriteSeeker, r *archive.Reader) (*archive.Header, error)
+
+// rewriteArchive replaces the archive file with the copy written by the rewrite function.
+// The copy is written to a temporary file in the same directory, which is then renamed
+// over the archive, so that the archive is never left half written. The password of
+// archives with encrypted files is prompted for, as the copy is encrypted with the
+// same key.
+func rewriteArchive(fileName string, rewrite rewriteFunc) *archive.Header {
+	reader, closeArchive, err := openArchive(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeArchive()
+
+	if err := unlockArchive(reader); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	var header *archive.Header
+	err = replaceFile(fileName, func(f *os.File) error {
+		var err error
+		header, err = rewrite(f, reader)
+		return err
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	return header
+}
+
+// replaceFile replaces the file with the one written by the write function. It's written
+// to a temporary file in the same directory, with the same permissions, which is then
+// renamed over the file, so that the file is never left half written. The temporary file
+// is removed if anything fails.
+func replaceFile(fileName string, write func(f *os.File) error) error {
+	info, err := os.Stat(fileName)
+	if err != nil {
+		return err
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(fileName), ".aar-*.tmp")
+	if err != nil {
+		return err
+	}
+
+	err = write(tmpFile)
+	if err == nil {
+		err = tmpFile.Chmod(info.Mode().Perm())
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), fileName)
+	}
+
+	if err != nil {
+		os.Remove(tmpFile.Name())
+	}
+
+	return err
+}
@@ -0,0 +1,82 @@
+package cmd
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// StdioName is the file name that stands for the standard input or output.
+const StdioName = "-"
+
+// openArchive opens the archive file for reading, or the standard input if the file name
+// is StdioName. As the archive is read at random offsets, a piped standard input is
+// first copied into a temporary file. The password is prompted for if the archive's
+// header is encrypted. The returned function closes the archive.
+func openArchive(fileName string) (*archive.Reader, func(), error) {
+	if fileName != StdioName {
+		file, err := os.Open(fileName)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		reader, err := newArchiveReader(file)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+
+		return reader, func() { file.Close() }, nil
+	}
+
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode().IsRegular() {
+		reader, err := newArchiveReader(os.Stdin)
+		return reader, func() {}, err
+	}
+
+	tmpFile, err := os.CreateTemp("", "aar-stdin-*")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closeTmp := func() {
+		tmpFile.Close()
+		os.Remove(tmpFile.Name())
+	}
+
+	if _, err := io.Copy(tmpFile, os.Stdin); err != nil {
+		closeTmp()
+		return nil, nil, err
+	}
+
+	reader, err := newArchiveReader(tmpFile)
+	if err != nil {
+		closeTmp()
+		return nil, nil, err
+	}
+
+	return reader, closeTmp, nil
+}
+
+// newArchiveReader creates a Reader for the archive in r, prompting for the password
+// if its header is encrypted.
+func newArchiveReader(r io.ReaderAt) (*archive.Reader, error) {
+	reader, err := archive.NewReader(r)
+	if errors.Is(err, archive.ErrEncrypted) {
+		return archive.NewReader(r, archive.NewPasswordIdentity(PromptPassword()))
+	}
+
+	return reader, err
+}
+
+// unlockArchive prompts for the password of an archive whose files are encrypted, so
+// that their data can be read. Other archives need no password.
+func unlockArchive(reader *archive.Reader) error {
+	if !reader.Header.Locked() {
+		return nil
+	}
+
+	return reader.Header.Unlock(archive.NewPasswordIdentity(PromptPassword()))
+}
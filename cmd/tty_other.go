@@ -0,0 +1,6 @@
+//go:build !windows
+
+package cmd
+
+// ttyName is the name of the controlling terminal, where passwords are read from.
+const ttyName = "/dev/tty"
@@ -0,0 +1,6 @@
+//go:build windows
+
+package cmd
+
+// ttyName is the name of the console input, where passwords are read from.
+const ttyName = "CONIN$"
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/angelsolaorbaiceta/aar/archive"
+)
+
+// VerifyArchive checks that every file in the archive can be decompressed and matches
+// its checksum, without writing anything to disk. It reports the damaged files and
+// exits with a non-zero status if there's any.
+func VerifyArchive(fileName string) {
+	reader, closeArchive, err := openArchive(fileName)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archive: %v\n", err)
+		os.Exit(1)
+	}
+	defer closeArchive()
+
+	if err := unlockArchive(reader); err != nil {
+		fmt.Fprintf(os.Stderr, "Error decrypting archive: %v\n", err)
+		os.Exit(1)
+	}
+
+	if reader.Header.Flags&archive.FlagChecksum == 0 {
+		fmt.Fprintf(os.Stderr, "The archive has no checksums, only checking that files decompress.\n")
+	}
+
+	damaged := 0
+	for _, entry := range reader.Header.Entries {
+		if err := verifyEntry(reader, entry); err != nil {
+			damaged++
+			fmt.Fprintf(os.Stdout, "	> %s: DAMAGED (%v)\n", entry.Name, err)
+		} else {
+			fmt.Fprintf(os.Stdout, "	> %s: OK\n", entry.Name)
+		}
+	}
+
+	if damaged > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d files are damaged.\n", damaged, len(reader.Header.Entries))
+		os.Exit(1)
+	}
+
+	fmt.Fprintf(os.Stderr, "All %d files are OK.\n", len(reader.Header.Entries))
+}
+
+// verifyEntry decompresses the entry, discarding its data, which checks its checksum.
+func verifyEntry(reader *archive.Reader, entry *archive.HeaderFileEntry) error {
+	data, err := reader.OpenEntry(entry)
+	if err != nil {
+		return err
+	}
+	defer data.Close()
+
+	_, err = io.Copy(io.Discard, data)
+	return err
+}